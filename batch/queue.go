@@ -0,0 +1,430 @@
+// Package batch implements the buffering shared by network outputs. Written
+// lines are queued and handed to a send function in batches, failed batches
+// are retried with an exponential backoff.
+package batch
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rs/golp/internal/diag"
+)
+
+// TimestampFunc is called to timestamp queued entries.
+var TimestampFunc = time.Now
+
+const (
+	minBackoff = 100 * time.Millisecond
+	maxBackoff = 30 * time.Second
+)
+
+// Overflow policies, applied to new entries when the queue is full.
+const (
+	// DropOldest drops the oldest entry to make room for the new one.
+	DropOldest = "drop-oldest"
+	// DropNewest drops the new entry.
+	DropNewest = "drop-newest"
+	// Sample keeps one new entry out of the sample rate, dropping the oldest
+	// entry to make room for it, and drops the others.
+	Sample = "sample"
+)
+
+// ReportFunc returns the line queued to report that dropped entries were
+// dropped.
+type ReportFunc func(dropped int) []byte
+
+// Entry is a line queued for sending.
+type Entry struct {
+	// Time is the time the line was written to the queue.
+	Time time.Time
+	// Line is the content of the line without its trailing new line.
+	Line []byte
+}
+
+// SendFunc sends a batch of entries. A batch is sent again if an error is
+// returned, unless the error is wrapped with Permanent.
+type SendFunc func(entries []Entry) error
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+// Permanent wraps err so the batch is dropped instead of retried.
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+// Queue is an io.WriteCloser queuing each written line. Writes never block on
+// the send function which is called from a background goroutine.
+type Queue struct {
+	name    string
+	send    SendFunc
+	size    int
+	limit   int
+	age     time.Duration
+	retries int
+	// overflow is the overflow policy, sampleRate its rate with Sample
+	overflow   string
+	sampleRate int
+	report     ReportFunc
+	reportAge  time.Duration
+	// closeTimeout is the maximum time Close waits for the entries to be sent
+	closeTimeout time.Duration
+
+	mu      sync.Mutex
+	partial []byte
+	entries []Entry
+	// overflowed is the number of entries written while the queue was full
+	// and dropped the number of entries dropped since the last report
+	overflowed int
+	dropped    int
+	// sending is the number of entries being sent
+	sending int
+
+	kick      chan struct{}
+	done      chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// Option configures a Queue.
+type Option func(q *Queue) error
+
+// New creates a queue handing batches to send. The name is used to prefix
+// logged errors.
+func New(name string, send SendFunc, options ...Option) (*Queue, error) {
+	q := &Queue{
+		name:     name,
+		send:     send,
+		size:     100,
+		limit:    10000,
+		age:      500 * time.Millisecond,
+		overflow: DropOldest,
+		kick:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
+	for _, option := range options {
+		if err := option(q); err != nil {
+			return nil, err
+		}
+	}
+	go q.loop()
+	return q, nil
+}
+
+// Size sets the maximum number of entries in a batch. A batch is sent as soon
+// as it is full.
+func Size(n int) Option {
+	return func(q *Queue) error {
+		if n < 1 {
+			return errors.New("batch size must be positive")
+		}
+		q.size = n
+		return nil
+	}
+}
+
+// Age sets the maximum time an entry waits for its batch to fill.
+func Age(d time.Duration) Option {
+	return func(q *Queue) error {
+		if d <= 0 {
+			return errors.New("batch age must be positive")
+		}
+		q.age = d
+		return nil
+	}
+}
+
+// Limit sets the maximum number of entries kept while batches can't be sent.
+// Oldest entries are dropped first.
+func Limit(n int) Option {
+	return func(q *Queue) error {
+		if n < 1 {
+			return errors.New("buffer limit must be positive")
+		}
+		q.limit = n
+		return nil
+	}
+}
+
+// Overflow sets the overflow policy (DropOldest or DropNewest) applied to new
+// entries when the queue is full. Default is DropOldest.
+func Overflow(policy string) Option {
+	return func(q *Queue) error {
+		switch policy {
+		case DropOldest, DropNewest:
+		default:
+			return errors.New("invalid overflow policy: " + policy)
+		}
+		q.overflow = policy
+		return nil
+	}
+}
+
+// SampleRate sets the Sample overflow policy, keeping one out of rate new
+// entries when the queue is full.
+func SampleRate(rate int) Option {
+	return func(q *Queue) error {
+		if rate < 1 {
+			return errors.New("sample rate must be positive")
+		}
+		q.overflow, q.sampleRate = Sample, rate
+		return nil
+	}
+}
+
+// Report queues the line returned by report every interval if entries were
+// dropped since the last report, instead of logging each drop. The report is
+// queued even if the queue is full.
+func Report(interval time.Duration, report ReportFunc) Option {
+	return func(q *Queue) error {
+		if interval <= 0 {
+			return errors.New("report interval must be positive")
+		}
+		q.report, q.reportAge = report, interval
+		return nil
+	}
+}
+
+// CloseTimeout sets the maximum time Close waits for the queued entries to be
+// sent. The entries not sent in time are dropped. With 0, Close waits until
+// they are sent or fail to be.
+func CloseTimeout(d time.Duration) Option {
+	return func(q *Queue) error {
+		if d < 0 {
+			return errors.New("close timeout must not be negative")
+		}
+		q.closeTimeout = d
+		return nil
+	}
+}
+
+// Retries sets the number of times a batch is sent again before being
+// dropped. With 0, batches are retried until the queue is closed.
+func Retries(n int) Option {
+	return func(q *Queue) error {
+		q.retries = n
+		return nil
+	}
+}
+
+// Write queues an entry for each complete line of p. Incomplete lines are kept
+// until the end of line is written.
+func (q *Queue) Write(p []byte) (n int, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.partial = append(q.partial, p...)
+	dropped := 0
+	for {
+		i := bytes.IndexByte(q.partial, '\n')
+		if i == -1 {
+			break
+		}
+		if i > 0 {
+			line := make([]byte, i)
+			copy(line, q.partial)
+			if !q.push(Entry{Time: TimestampFunc(), Line: line}) {
+				dropped++
+			}
+		}
+		q.partial = q.partial[i+1:]
+	}
+	if len(q.partial) == 0 {
+		q.partial = nil
+	}
+	if dropped > 0 {
+		if q.report != nil {
+			q.dropped += dropped
+		} else {
+			diag.Printf("golp: %s buffer full, dropping %d entries", q.name, dropped)
+		}
+	}
+	if len(q.entries) >= q.size {
+		select {
+		case q.kick <- struct{}{}:
+		default:
+		}
+	}
+	return len(p), nil
+}
+
+// push queues e according to the overflow policy and returns false if an
+// entry, e or the oldest one, was dropped.
+func (q *Queue) push(e Entry) bool {
+	if len(q.entries) < q.limit {
+		q.entries = append(q.entries, e)
+		return true
+	}
+	q.overflowed++
+	switch q.overflow {
+	case DropNewest:
+		return false
+	case Sample:
+		if q.overflowed%q.sampleRate != 0 {
+			return false
+		}
+	}
+	q.entries = append(q.entries[1:], e)
+	return false
+}
+
+// queueReport queues the report of the entries dropped since the last report
+// if any.
+func (q *Queue) queueReport() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.dropped == 0 {
+		return
+	}
+	line := bytes.TrimSuffix(q.report(q.dropped), []byte{'\n'})
+	q.dropped = 0
+	if len(line) > 0 {
+		q.entries = append(q.entries, Entry{Time: TimestampFunc(), Line: line})
+	}
+}
+
+// Close sends the queued entries and stops the background goroutine. Entries
+// that can not be sent, or not within the CloseTimeout, are dropped. After a
+// timeout, the background goroutine stops once the pending send returns.
+func (q *Queue) Close() error {
+	q.closeOnce.Do(func() {
+		close(q.done)
+		if q.closeTimeout == 0 {
+			<-q.closed
+			return
+		}
+		t := time.NewTimer(q.closeTimeout)
+		defer t.Stop()
+		select {
+		case <-q.closed:
+		case <-t.C:
+			q.mu.Lock()
+			dropped := q.sending + len(q.entries)
+			q.entries = nil
+			q.mu.Unlock()
+			diag.Printf("golp: %s close timeout, dropping %d entries", q.name, dropped)
+		}
+	})
+	return nil
+}
+
+// sendBatch sends batch, counting its entries as being sent.
+func (q *Queue) sendBatch(batch []Entry) error {
+	q.mu.Lock()
+	q.sending = len(batch)
+	q.mu.Unlock()
+	err := q.send(batch)
+	q.mu.Lock()
+	q.sending = 0
+	q.mu.Unlock()
+	return err
+}
+
+// next removes and returns the next batch of queued entries.
+func (q *Queue) next() []Entry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := len(q.entries)
+	if n > q.size {
+		n = q.size
+	}
+	if n == 0 {
+		return nil
+	}
+	batch := make([]Entry, n)
+	copy(batch, q.entries)
+	q.entries = q.entries[n:]
+	return batch
+}
+
+func (q *Queue) loop() {
+	defer close(q.closed)
+	t := time.NewTicker(q.age)
+	defer t.Stop()
+	var report <-chan time.Time
+	if q.report != nil {
+		r := time.NewTicker(q.reportAge)
+		defer r.Stop()
+		report = r.C
+	}
+	backoff := minBackoff
+	attempts := 0
+	var batch []Entry
+	for {
+		select {
+		case <-q.kick:
+		case <-t.C:
+		case <-report:
+			q.queueReport()
+		case <-q.done:
+			q.drain(batch)
+			return
+		}
+		for {
+			select {
+			case <-report:
+				// The queue may never empty while entries are dropped
+				q.queueReport()
+			default:
+			}
+			if len(batch) == 0 {
+				batch = q.next()
+			}
+			if len(batch) == 0 {
+				break
+			}
+			err := q.sendBatch(batch)
+			if err == nil {
+				backoff, attempts, batch = minBackoff, 0, nil
+				continue
+			}
+			attempts++
+			if _, ok := err.(permanentError); ok || (q.retries > 0 && attempts > q.retries) {
+				diag.Printf("golp: %s error: %v, dropping %d entries", q.name, err, len(batch))
+				backoff, attempts, batch = minBackoff, 0, nil
+				continue
+			}
+			diag.Printf("golp: %s error: %v", q.name, err)
+			select {
+			case <-time.After(backoff):
+			case <-q.done:
+				q.drain(batch)
+				return
+			}
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		}
+	}
+}
+
+// drain makes a last attempt at sending pending entries before closing.
+func (q *Queue) drain(batch []Entry) {
+	if q.report != nil {
+		q.queueReport()
+	}
+	for {
+		if len(batch) == 0 {
+			batch = q.next()
+		}
+		if len(batch) == 0 {
+			return
+		}
+		if err := q.sendBatch(batch); err != nil {
+			q.mu.Lock()
+			dropped := len(batch) + len(q.entries)
+			q.entries = nil
+			q.mu.Unlock()
+			diag.Printf("golp: %s error: %v, dropping %d entries", q.name, err, dropped)
+			return
+		}
+		batch = nil
+	}
+}
@@ -0,0 +1,184 @@
+package batch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/golp/internal/diag"
+)
+
+func lines(entries []Entry) []string {
+	l := make([]string, len(entries))
+	for i, e := range entries {
+		l[i] = string(e.Line)
+	}
+	return l
+}
+
+func TestQueueSize(t *testing.T) {
+	sent := make(chan []string, 10)
+	q, _ := New("test", func(entries []Entry) error {
+		sent <- lines(entries)
+		return nil
+	}, Size(2), Age(time.Hour))
+	q.Write([]byte("a\nb"))
+	q.Write([]byte("\nc\n"))
+	select {
+	case got := <-sent:
+		if want := []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for batch")
+	}
+	q.Close()
+	if got, want := <-sent, []string{"c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q on close, want %q", got, want)
+	}
+}
+
+func TestQueueRetry(t *testing.T) {
+	tests := map[string]struct {
+		err     error
+		retries int
+		calls   int
+	}{
+		"retried":   {errors.New("temporary"), 0, 2},
+		"permanent": {Permanent(errors.New("permanent")), 0, 1},
+		"exhausted": {errors.New("temporary"), 1, 2},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			calls := 0
+			done := make(chan bool, 10)
+			q, _ := New("test", func(entries []Entry) error {
+				calls++
+				defer func() { done <- true }()
+				if calls == 1 || tt.retries > 0 {
+					return tt.err
+				}
+				return nil
+			}, Size(1), Retries(tt.retries))
+			defer q.Close()
+			q.Write([]byte("a\n"))
+			for i := 0; i < tt.calls; i++ {
+				select {
+				case <-done:
+				case <-time.After(5 * time.Second):
+					t.Fatal("timeout waiting for send")
+				}
+			}
+			select {
+			case <-done:
+				t.Errorf("unexpected extra send")
+			case <-time.After(300 * time.Millisecond):
+			}
+		})
+	}
+}
+
+func TestQueueLimit(t *testing.T) {
+	q := &Queue{size: 100, limit: 2, kick: make(chan struct{}, 1)}
+	q.Write([]byte("a\nb\nc\n"))
+	if got, want := lines(q.entries), []string{"b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestQueueOverflow(t *testing.T) {
+	tests := map[string]struct {
+		option  Option
+		entries []string
+		dropped int
+	}{
+		"drop oldest": {Overflow(DropOldest), []string{"e", "f"}, 4},
+		"drop newest": {Overflow(DropNewest), []string{"a", "b"}, 4},
+		"sample":      {SampleRate(2), []string{"d", "f"}, 4},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			q := &Queue{size: 100, limit: 2, kick: make(chan struct{}, 1)}
+			tt.option(q)
+			Report(time.Hour, func(dropped int) []byte {
+				return []byte(fmt.Sprintf("%d dropped\n", dropped))
+			})(q)
+			q.Write([]byte("a\nb\nc\nd\ne\nf\n"))
+			if got, want := lines(q.entries), tt.entries; !reflect.DeepEqual(got, want) {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			if got, want := q.dropped, tt.dropped; got != want {
+				t.Errorf("invalid dropped: got %v, want %v", got, want)
+			}
+			q.queueReport()
+			if got, want := lines(q.entries), append(tt.entries, "4 dropped"); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestWriterNonBlocking(t *testing.T) {
+	out := &blockingWriter{unblock: make(chan struct{})}
+	q, _ := NewWriter(out, Limit(2), Overflow(DropNewest), Report(time.Hour, func(dropped int) []byte {
+		return []byte(fmt.Sprintf("%d dropped", dropped))
+	}))
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			q.Write([]byte("line\n"))
+		}
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("write blocked")
+	}
+	close(out.unblock)
+	q.Close()
+	if !strings.HasPrefix(out.String(), "line\n") || !strings.HasSuffix(out.String(), " dropped\n") {
+		t.Errorf("invalid output: %q", out.String())
+	}
+}
+
+// blockingWriter blocks writes until unblock is closed.
+type blockingWriter struct {
+	bytes.Buffer
+	unblock chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.unblock
+	return w.Buffer.Write(p)
+}
+
+func TestWriterCloseTimeout(t *testing.T) {
+	logs := &bytes.Buffer{}
+	diag.SetOutput(logs)
+	defer diag.SetOutput(os.Stderr)
+	// The output never returns
+	out := &blockingWriter{unblock: make(chan struct{})}
+	defer close(out.unblock)
+	q, _ := NewWriter(out, Size(2), CloseTimeout(50*time.Millisecond))
+	q.Write([]byte("a\nb\nc\n"))
+	time.Sleep(50 * time.Millisecond)
+	done := make(chan bool)
+	go func() {
+		q.Close()
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("close blocked")
+	}
+	if got, want := logs.String(), "golp: output close timeout, dropping 3 entries\n"; !strings.HasSuffix(got, want) {
+		t.Errorf("got %q, want suffix %q", got, want)
+	}
+}
@@ -0,0 +1,31 @@
+package batch
+
+import (
+	"io"
+	"time"
+)
+
+// NewWriter returns a Queue writing the queued lines to w from its background
+// goroutine, so a slow or blocked w never blocks writes. Batches failing to be
+// written are dropped. Batches are written at least every 10ms unless the Age
+// option is given, and Close waits at most 5s for the queued lines to be
+// written unless the CloseTimeout option is given.
+func NewWriter(w io.Writer, options ...Option) (*Queue, error) {
+	options = append([]Option{Age(10 * time.Millisecond), CloseTimeout(5 * time.Second)}, options...)
+	return New("output", func(entries []Entry) error {
+		n := 0
+		for _, e := range entries {
+			n += len(e.Line) + 1
+		}
+		b := make([]byte, 0, n)
+		for _, e := range entries {
+			b = append(b, e.Line...)
+			b = append(b, '\n')
+		}
+		if _, err := w.Write(b); err != nil {
+			// A partial write must not be written again
+			return Permanent(err)
+		}
+		return nil
+	}, options...)
+}
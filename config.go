@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// loadConfig sets the flags not set on the command line from the JSON object
+// stored in the file at path. Keys are flag names. The values of repeated
+// key=value flags like ctx are objects merged with the command line pairs,
+// non string ctx values being typed.
+func loadConfig(fs *flag.FlagSet, path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var config map[string]json.RawMessage
+	if err := json.Unmarshal(b, &config); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	names := make([]string, 0, len(config))
+	for name := range config {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := fs.Lookup(name)
+		if f == nil || !isSetting(name) {
+			return fmt.Errorf("%s: unknown setting: %s", path, name)
+		}
+		if err := setFlag(fs, f, config[name], set[name]); err != nil {
+			return fmt.Errorf("%s: %s: %v", path, name, err)
+		}
+	}
+	return nil
+}
+
+// setFlag sets the flag f from its raw JSON config value. Flags set on the
+// command line are kept.
+func setFlag(fs *flag.FlagSet, f *flag.Flag, raw json.RawMessage, set bool) error {
+	if c, ok := f.Value.(keyValues); ok {
+		var values map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &values); err != nil {
+			return errors.New("must be an object")
+		}
+		keys := make([]string, 0, len(values))
+		for k := range values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if c.has(k) {
+				// Command line wins
+				continue
+			}
+			var s string
+			if json.Unmarshal(values[k], &s) == nil {
+				if err := c.Set(k + "=" + s); err != nil {
+					return err
+				}
+				continue
+			}
+			if _, typed := c.(*typedContext); !typed {
+				return fmt.Errorf("%s must be a string", k)
+			}
+			if err := c.Set(k + ":=" + string(values[k])); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if set {
+		return nil
+	}
+	var value string
+	var list []string
+	if json.Unmarshal(raw, &value) == nil {
+	} else if json.Unmarshal(raw, &list) == nil {
+		value = strings.Join(list, ",")
+	} else {
+		value = string(raw)
+	}
+	return fs.Set(f.Name, value)
+}
+
+// isSetting returns false for the flags selecting a mode of golp rather than a
+// setting, which can't be set from the config file.
+func isSetting(name string) bool {
+	switch name {
+	case "config", "check-config", "print-env":
+		return false
+	}
+	return true
+}
+
+// keyValues is a repeated key=value flag.
+type keyValues interface {
+	flag.Value
+	has(key string) bool
+}
+
+// printConfig writes the effective configuration as a config file to w.
+func printConfig(fs *flag.FlagSet, w io.Writer) error {
+	config := map[string]interface{}{}
+	fs.VisitAll(func(f *flag.Flag) {
+		if !isSetting(f.Name) {
+			return
+		}
+		switch v := f.Value.(type) {
+		case *typedContext:
+			values := map[string]interface{}{}
+			for k, s := range v.context {
+				values[k] = s
+				if raw, found := v.typed[k]; found {
+					values[k] = raw
+				}
+			}
+			config[f.Name] = values
+		case *context:
+			config[f.Name] = *v
+		case flag.Getter:
+			value := v.Get()
+			if d, ok := value.(time.Duration); ok {
+				value = d.String()
+			}
+			config[f.Name] = value
+		default:
+			config[f.Name] = v.String()
+		}
+	})
+	b, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMain runs golp with the arguments of the TEST_GOLP_ARGS environment
+// variable when set, for tests checking the behavior of the command.
+func TestMain(m *testing.M) {
+	if args, found := os.LookupEnv("TEST_GOLP_ARGS"); found {
+		os.Args = append([]string{"golp"}, strings.Fields(args)...)
+		// Leave the test flags out of the configuration
+		flag.CommandLine = flag.NewFlagSet("golp", flag.ExitOnError)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestPrecedence(t *testing.T) {
+	tests := map[string]struct {
+		args    []string
+		environ []string
+		maxLen  int
+	}{
+		"config":  {nil, nil, 10},
+		"env":     {nil, []string{"GOLP_MAX_LEN=20"}, 20},
+		"cli":     {[]string{"-max-len", "30"}, nil, 30},
+		"cli_env": {[]string{"-max-len", "30"}, []string{"GOLP_MAX_LEN=20"}, 30},
+	}
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"max-len": 10}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			fs := flag.NewFlagSet("golp", flag.ContinueOnError)
+			maxLen := fs.Int("max-len", 0, "")
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			if err := loadEnv(fs, tt.environ); err != nil {
+				t.Fatal(err)
+			}
+			if err := loadConfig(fs, path); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := *maxLen, tt.maxLen; got != want {
+				t.Errorf("got %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	tests := map[string]struct {
+		args   string
+		config map[string]interface{}
+		err    string
+	}{
+		"valid":   {"-check-config -json -max-len 100", map[string]interface{}{"json": true, "max-len": 100.0}, ""},
+		"invalid": {"-check-config -json -profile ruby", nil, "invalid profile: ruby"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			cmd := exec.Command(os.Args[0])
+			cmd.Env = append(os.Environ(), "TEST_GOLP_ARGS="+tt.args)
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			cmd.Stdout, cmd.Stderr = stdout, stderr
+			err := cmd.Run()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(stderr.String(), tt.err) {
+					t.Errorf("got error %v (%s), want %q", err, stderr, tt.err)
+				}
+				// Nothing is printed for an invalid configuration
+				if stdout.Len() > 0 {
+					t.Errorf("got output %s", stdout)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%v: %s", err, stderr)
+			}
+			config := map[string]interface{}{}
+			if err := json.Unmarshal(stdout.Bytes(), &config); err != nil {
+				t.Fatal(err)
+			}
+			for k, want := range tt.config {
+				if got := config[k]; got != want {
+					t.Errorf("got %s=%v, want %v", k, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTypedContextPrecedence(t *testing.T) {
+	tests := map[string]struct {
+		args    []string
+		environ []string
+		context map[string]string
+		typed   map[string]string
+	}{
+		"config":  {nil, nil, map[string]string{"port": "8080", "ok": "true"}, map[string]string{"port": "8080", "ok": "true"}},
+		"env":     {nil, []string{"GOLP_CTX_port=http"}, map[string]string{"port": "http", "ok": "true"}, map[string]string{"ok": "true"}},
+		"cli":     {[]string{"-ctx", "port=http"}, nil, map[string]string{"port": "http", "ok": "true"}, map[string]string{"ok": "true"}},
+		"cli_env": {[]string{"-ctx", "port:=80"}, []string{"GOLP_CTX_port=http"}, map[string]string{"port": "80", "ok": "true"}, map[string]string{"port": "80", "ok": "true"}},
+		"cli_set": {[]string{"-ctx", "port:=80", "-ctx", "port=http", "-ctx", "ok=yes", "-ctx", "ok:=false"}, nil, map[string]string{"port": "http", "ok": "false"}, map[string]string{"ok": "false"}},
+	}
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"ctx": {"port": 8080, "ok": true}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			fs := flag.NewFlagSet("golp", flag.ContinueOnError)
+			ctx := newTypedContext()
+			fs.Var(ctx, "ctx", "")
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			if err := loadEnv(fs, tt.environ); err != nil {
+				t.Fatal(err)
+			}
+			if err := loadConfig(fs, path); err != nil {
+				t.Fatal(err)
+			}
+			typed := map[string]string{}
+			for k, v := range ctx.typed {
+				typed[k] = string(v)
+			}
+			got, _ := json.Marshal([]interface{}{ctx.context, typed})
+			want, _ := json.Marshal([]interface{}{tt.context, tt.typed})
+			if string(got) != string(want) {
+				t.Errorf("got %s, want %s", got, want)
+			}
+		})
+	}
+}
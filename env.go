@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// envPrefix is the prefix of the environment variables setting options.
+const envPrefix = "GOLP_"
+
+// envName returns the name of the environment variable of the flag name.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// loadEnv sets the flags not set on the command line from the GOLP_ variables
+// of environ. A flag is set by the variable named after it (i.e.: GOLP_MAX_LEN
+// for max-len), and a repeated key=value flag by the family of variables
+// prefixed by its variable name followed by the key (i.e.: GOLP_CTX_service for
+// the service ctx key). Variables named after a flag take precedence over
+// families: GOLP_CTX_NESTED sets ctx-nested, so NESTED is a reserved ctx key
+// (listed by printEnv).
+func loadEnv(fs *flag.FlagSet, environ []string) error {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	names := map[string]*flag.Flag{}
+	var families []*flag.Flag
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "print-env" {
+			return
+		}
+		if _, ok := f.Value.(keyValues); ok {
+			families = append(families, f)
+			return
+		}
+		names[envName(f.Name)] = f
+	})
+	sort.Strings(environ)
+	for _, kv := range environ {
+		i := strings.IndexByte(kv, '=')
+		if i < 0 || !strings.HasPrefix(kv[:i], envPrefix) {
+			continue
+		}
+		name, value := kv[:i], kv[i+1:]
+		if f, found := names[name]; found {
+			if set[f.Name] {
+				continue
+			}
+			if err := fs.Set(f.Name, value); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			continue
+		}
+		for _, f := range families {
+			prefix := envName(f.Name) + "_"
+			if !strings.HasPrefix(name, prefix) || len(name) == len(prefix) {
+				continue
+			}
+			key := name[len(prefix):]
+			if f.Value.(keyValues).has(key) {
+				// Command line wins
+				break
+			}
+			if err := fs.Set(f.Name, key+"="+value); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// printEnv writes the recognized environment variables to w.
+func printEnv(fs *flag.FlagSet, w io.Writer) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "print-env" || err != nil {
+			return
+		}
+		name, usage := envName(f.Name), f.Usage
+		if _, ok := f.Value.(keyValues); ok {
+			name += "_<key>"
+			if keys := reservedKeys(fs, f); len(keys) > 0 {
+				usage += " Reserved keys, set by other variables: " + strings.Join(keys, ", ") + "."
+			}
+		}
+		_, err = fmt.Fprintf(w, "  %s\n    \t%s\n", name, usage)
+	})
+	return err
+}
+
+// reservedKeys returns the keys of the repeated key=value flag f which can't
+// be set from the environment because their variable is named after another
+// flag.
+func reservedKeys(fs *flag.FlagSet, f *flag.Flag) []string {
+	prefix := envName(f.Name) + "_"
+	var keys []string
+	fs.VisitAll(func(o *flag.Flag) {
+		if name := envName(o.Name); strings.HasPrefix(name, prefix) {
+			keys = append(keys, name[len(prefix):])
+		}
+	})
+	return keys
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestEnvReservedKeys(t *testing.T) {
+	fs := flag.NewFlagSet("golp", flag.ContinueOnError)
+	ctx := newTypedContext()
+	fs.Var(ctx, "ctx", "A key=value.")
+	nested := fs.Bool("ctx-nested", false, "Nest keys.")
+	if err := loadEnv(fs, []string{"GOLP_CTX_NESTED=true", "GOLP_CTX_service=api"}); err != nil {
+		t.Fatal(err)
+	}
+	if !*nested || len(ctx.context) != 1 || ctx.context["service"] != "api" {
+		t.Errorf("got ctx-nested=%v ctx=%v, want ctx-nested=true ctx=map[service:api]", *nested, ctx.context)
+	}
+	out := &bytes.Buffer{}
+	if err := printEnv(fs, out); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "A key=value. Reserved keys, set by other variables: NESTED."; !strings.Contains(got, want) {
+		t.Errorf("got %q, want it to contain %q", got, want)
+	}
+}
+
+func TestEnvScalars(t *testing.T) {
+	tests := map[string]struct {
+		args    []string
+		environ []string
+		want    string
+		err     string
+	}{
+		"int":      {nil, []string{"GOLP_MAX_LEN=10"}, "max-len=10", ""},
+		"bool":     {nil, []string{"GOLP_JSON=true"}, "json=true", ""},
+		"duration": {nil, []string{"GOLP_FLUSH_DELAY=1s"}, "flush-delay=1s", ""},
+		"string":   {nil, []string{"GOLP_JSON_KEY=msg"}, "json-key=msg", ""},
+		"cli":      {[]string{"-max-len", "20"}, []string{"GOLP_MAX_LEN=10"}, "max-len=20", ""},
+		"other":    {nil, []string{"MAX_LEN=10", "GOLP_UNKNOWN=1"}, "", ""},
+		"invalid":  {nil, []string{"GOLP_MAX_LEN=ten"}, "", "GOLP_MAX_LEN: "},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			fs := flag.NewFlagSet("golp", flag.ContinueOnError)
+			fs.Int("max-len", 0, "")
+			fs.Bool("json", false, "")
+			fs.Duration("flush-delay", 0, "")
+			fs.String("json-key", "message", "")
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			err := loadEnv(fs, tt.environ)
+			if tt.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+					t.Errorf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var set []string
+			fs.Visit(func(f *flag.Flag) {
+				set = append(set, f.Name+"="+f.Value.String())
+			})
+			if got := strings.Join(set, " "); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnvFamilyReservedKeys(t *testing.T) {
+	tests := map[string]struct {
+		environ []string
+		nested  bool
+		context map[string]string
+		err     bool
+	}{
+		"reserved":   {[]string{"GOLP_CTX_NESTED=true"}, true, map[string]string{}, false},
+		"not a bool": {[]string{"GOLP_CTX_NESTED=api"}, false, map[string]string{}, true},
+		"lower case": {[]string{"GOLP_CTX_nested=api"}, false, map[string]string{"nested": "api"}, false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			fs := flag.NewFlagSet("golp", flag.ContinueOnError)
+			ctx := newTypedContext()
+			fs.Var(ctx, "ctx", "")
+			nested := fs.Bool("ctx-nested", false, "")
+			err := loadEnv(fs, tt.environ)
+			if got, want := err != nil, tt.err; got != want {
+				t.Fatalf("got error %v, want error %v", err, want)
+			}
+			if *nested != tt.nested || len(ctx.context) != len(tt.context) || ctx.context["nested"] != tt.context["nested"] {
+				t.Errorf("got ctx-nested=%v ctx=%v, want ctx-nested=%v ctx=%v", *nested, ctx.context, tt.nested, tt.context)
+			}
+		})
+	}
+}
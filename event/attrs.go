@@ -0,0 +1,98 @@
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Attr is a field added to an event with SetAttrs, like an attribute of a
+// structured log record. Its value is JSON encoded.
+type Attr struct {
+	Key   string
+	Value json.RawMessage
+}
+
+// SetAttrs sets the fields of the current event. Attrs are added to the JSON
+// or logfmt output before the context, and are cleared when the event is
+// flushed. In logfmt output, object values are written as dotted keys. Attrs
+// replace the context values of the same keys, or are dropped with the
+// MergeContext JSONMerge policy. Attrs named after the message key are
+// dropped. With MaxLen, they are accounted in the envelope of the event and
+// the last attrs are dropped if the envelope would not fit. SetAttrs must be
+// called before writing the event message.
+func (e *Event) SetAttrs(attrs []Attr) {
+	done := make(chan struct{})
+	e.write <- (func() {
+		e.attrs = e.attrs[:0]
+		for _, a := range attrs {
+			if a.Key == e.messageKey || e.jsonMerge == MergeContext && e.inContext(a.Key) {
+				continue
+			}
+			if e.logfmt {
+				e.attrs = appendLogfmtAttr(e.attrs, a.Key, a.Value)
+				continue
+			}
+			e.attrs = append(e.attrs, field{a.Key, string(a.Value)})
+		}
+		for len(e.attrs) > 0 && e.maxLen > 0 && e.overhead() >= e.maxLen {
+			e.attrs = e.attrs[:len(e.attrs)-1]
+		}
+		close(done)
+	})
+	<-done
+}
+
+// inContext returns true if key is a context key of the output.
+func (e *Event) inContext(key string) bool {
+	if _, found := e.dynamic[key]; found {
+		return true
+	}
+	for _, f := range e.ctxFields {
+		if f.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAttr returns true if key is the key of an attr of the current event.
+func (e *Event) hasAttr(key string) bool {
+	for _, a := range e.attrs {
+		if a.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutAttrs returns the fields without those replaced by attrs.
+func (e *Event) withoutAttrs(fields []field) []field {
+	if len(e.attrs) == 0 {
+		return fields
+	}
+	kept := fields[:0]
+	for _, f := range fields {
+		if !e.hasAttr(f.key) {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// recordPrefix returns the prefix of the output without the context fields
+// replaced by attrs.
+func (e *Event) recordPrefix() []byte {
+	replaced := false
+	for _, f := range e.ctxFields {
+		replaced = replaced || e.hasAttr(f.key)
+	}
+	if !replaced {
+		return e.prefix
+	}
+	if e.logfmt {
+		prefix := appendLogfmtKey(e.appendFields(nil, e.withoutAttrs(append([]field{}, e.ctxFields...))), e.messageKey)
+		return append(prefix, '=', '"')
+	}
+	prefix := e.appendFields([]byte{'{'}, e.withoutAttrs(append([]field{}, e.ctxFields...)))
+	return append(prefix, fmt.Sprintf(`"%s":"`, e.messageKey)...)
+}
@@ -0,0 +1,67 @@
+package event
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSetAttrs(t *testing.T) {
+	attrs := []Attr{{"level", []byte(`"ERROR"`)}, {"n", []byte(`1`)}}
+	tests := []struct {
+		name    string
+		options []Option
+		attrs   []Attr
+		output  string
+	}{
+		{"json", []Option{JSONOutput("message", map[string]string{"app": "x"})}, nil,
+			`{"level":"ERROR","n":1,"app":"x","message":"abcdefghijklmnopqrstuvwxyz"}` + "\n" +
+				`{"app":"x","message":"next"}` + "\n"},
+		{"logfmt", []Option{Logfmt("msg", nil)}, nil,
+			`level=ERROR n=1 msg="abcdefghijklmnopqrstuvwxyz"` + "\n" +
+				`msg="next"` + "\n"},
+		{"max len", []Option{MaxLen(50), JSONOutput("message", nil)}, nil,
+			`{"level":"ERROR","n":1,"message":"abcdef[20]..."}` + "\n" +
+				`{"message":"next"}` + "\n"},
+		{"max len drop", []Option{MaxLen(36), JSONOutput("message", nil)}, nil,
+			`{"level":"ERROR","message":"abcde"}` + "\n" +
+				`{"message":"next"}` + "\n"},
+		{"context", []Option{JSONOutput("message", map[string]string{"app": "x", "level": "info", "seq": SeqPlaceholder})}, nil,
+			`{"level":"ERROR","n":1,"seq":1,"app":"x","message":"abcdefghijklmnopqrstuvwxyz"}` + "\n" +
+				`{"seq":2,"app":"x","level":"info","message":"next"}` + "\n"},
+		{"merge context", []Option{JSONMerge(MergeContext, ""), JSONOutput("message", map[string]string{"level": "info"})}, nil,
+			`{"n":1,"level":"info","message":"abcdefghijklmnopqrstuvwxyz"}` + "\n" +
+				`{"level":"info","message":"next"}` + "\n"},
+		{"dynamic context", []Option{JSONOutput("message", map[string]string{"seq": SeqPlaceholder})}, []Attr{{"seq", []byte(`"a"`)}},
+			`{"seq":"a","message":"abcdefghijklmnopqrstuvwxyz"}` + "\n" +
+				`{"seq":2,"message":"next"}` + "\n"},
+		{"message key", []Option{JSONOutput("n", nil)}, nil,
+			`{"level":"ERROR","n":"abcdefghijklmnopqrstuvwxyz"}` + "\n" +
+				`{"n":"next"}` + "\n"},
+		{"logfmt context", []Option{Logfmt("msg", map[string]string{"level": "info", "req.id": "r0"})},
+			[]Attr{{"req", []byte(`{"id":"r 1","ok":true,"tags":["a"],"sub":{"n":1}}`)}},
+			`req.id="r 1" req.ok=true req.tags="[\"a\"]" req.sub.n=1 level=info msg="abcdefghijklmnopqrstuvwxyz"` + "\n" +
+				`level=info req.id=r0 msg="next"` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			e, err := New(out, tt.options...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer e.Close()
+			if tt.attrs != nil {
+				e.SetAttrs(tt.attrs)
+			} else {
+				e.SetAttrs(attrs)
+			}
+			e.Write([]byte("abcdefghijklmnopqrstuvwxyz"))
+			e.Flush()
+			e.Write([]byte("next"))
+			e.Flush()
+			if got, want := out.String(), tt.output; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
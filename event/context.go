@@ -0,0 +1,85 @@
+package event
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// TypedContext sets context values encoded as raw JSON (numbers, booleans,
+// null, objects or arrays) instead of strings in JSON output and JSON input.
+// The raw values replace the values of the same keys in the context given to
+// JSONOutput or AllowJSON. If nested is true, dotted context keys are expanded
+// into nested objects (i.e.: service.name=app gives {"service":{"name":"app"}}).
+// This option must be used before JSONOutput.
+func TypedContext(raw map[string]json.RawMessage, nested bool) Option {
+	return func(e *Event) error {
+		if len(e.prefix) > 0 {
+			return errors.New("TypedContext used after JSONOutput")
+		}
+		for k, v := range raw {
+			if !json.Valid(v) {
+				return errors.New("invalid JSON value for context key " + k)
+			}
+		}
+		e.typedContext = raw
+		e.nestedContext = nested
+		return nil
+	}
+}
+
+// contextMembers returns the context as JSON object members sorted by key,
+// with the typed values and the nested keys expanded.
+func (e *Event) contextMembers(context map[string]string) []member {
+	values := make(map[string]interface{}, len(context))
+	for k, v := range context {
+		if raw, found := e.typedContext[k]; found {
+			values[k] = raw
+			continue
+		}
+		values[k] = v
+	}
+	if e.nestedContext {
+		values = expandKeys(values)
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	members := make([]member, 0, len(keys))
+	for _, k := range keys {
+		members = append(members, newMember(k, values[k]))
+	}
+	return members
+}
+
+// expandKeys expands dotted keys of values into nested objects. When a key
+// is both a value and the parent of other keys, the nested object wins.
+func expandKeys(values map[string]interface{}) map[string]interface{} {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	// Parents are sorted before their children
+	sort.Strings(keys)
+	root := map[string]interface{}{}
+	for _, k := range keys {
+		path := strings.Split(k, ".")
+		node := root
+		for _, name := range path[:len(path)-1] {
+			child, ok := node[name].(map[string]interface{})
+			if !ok {
+				child = map[string]interface{}{}
+				node[name] = child
+			}
+			node = child
+		}
+		last := path[len(path)-1]
+		if _, ok := node[last].(map[string]interface{}); !ok {
+			node[last] = values[k]
+		}
+	}
+	return root
+}
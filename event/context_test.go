@@ -0,0 +1,60 @@
+package event
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestTypedContext(t *testing.T) {
+	ctx := map[string]string{"port": "8080", "sampled": "true", "service.name": "app", "service.version": "1.0", "tags": `["a"]`}
+	typed := map[string]json.RawMessage{"port": json.RawMessage("8080"), "sampled": json.RawMessage("true"), "tags": json.RawMessage(`["a"]`)}
+	tests := []struct {
+		name    string
+		options []Option
+		input   string
+		output  string
+	}{
+		{"strings", []Option{JSONOutput("message", ctx)}, "text",
+			`{"port":"8080","sampled":"true","service.name":"app","service.version":"1.0","tags":"[\"a\"]","message":"text"}`},
+		{"typed", []Option{TypedContext(typed, false), JSONOutput("message", ctx)}, "text",
+			`{"port":8080,"sampled":true,"service.name":"app","service.version":"1.0","tags":["a"],"message":"text"}`},
+		{"nested", []Option{TypedContext(typed, true), JSONOutput("message", ctx)}, "text",
+			`{"port":8080,"sampled":true,"service":{"name":"app","version":"1.0"},"tags":["a"],"message":"text"}`},
+		{"json input", []Option{TypedContext(typed, true), AllowJSON(true, ctx)}, `{"service":"x","msg":"y"}`,
+			`{"port":8080,"sampled":true,"tags":["a"],"service":"x","msg":"y"}`},
+		{"json input nest", []Option{TypedContext(typed, true), AllowJSON(true, ctx), JSONMerge(MergeNest, "ctx")}, `{"msg":"y"}`,
+			`{"ctx":{"port":8080,"sampled":true,"service":{"name":"app","version":"1.0"},"tags":["a"]},"msg":"y"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			e, err := New(out, tt.options...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer e.Close()
+			e.Write([]byte(tt.input))
+			e.Flush()
+			if got, want := out.String(), tt.output+"\n"; got != want {
+				t.Errorf("got %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestExpandKeys(t *testing.T) {
+	got, _ := json.Marshal(expandKeys(map[string]interface{}{"a": "1", "a.b": "2", "a.c.d": "3", "e": "4"}))
+	if want := `{"a":{"b":"2","c":{"d":"3"}},"e":"4"}`; string(got) != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestTypedContextInvalid(t *testing.T) {
+	if _, err := New(nil, TypedContext(map[string]json.RawMessage{"a": json.RawMessage("{")}, false)); err == nil {
+		t.Error("got no error for invalid JSON value")
+	}
+	if _, err := New(nil, JSONOutput("message", nil), TypedContext(nil, true)); err == nil {
+		t.Error("got no error for TypedContext after JSONOutput")
+	}
+}
@@ -0,0 +1,96 @@
+package event
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SeqPlaceholder is replaced in context values by the sequence number of the
+// event, starting at 1 and incremented for each event written. A context value
+// made of the placeholder only is written as a JSON number.
+const SeqPlaceholder = "${seq}"
+
+// dynamicContext returns the context without the values containing per event
+// placeholders, which are recorded to be evaluated at flush time.
+func (e *Event) dynamicContext(context map[string]string) map[string]string {
+	static := make(map[string]string, len(context))
+	for k, v := range context {
+		if !strings.Contains(v, SeqPlaceholder) {
+			static[k] = v
+			continue
+		}
+		if e.dynamic == nil {
+			e.dynamic = map[string]string{}
+		}
+		e.dynamic[k] = v
+	}
+	return static
+}
+
+// dynamicValues returns the dynamic context values evaluated for the event of
+// sequence number seq.
+func (e *Event) dynamicValues(seq uint64) map[string]string {
+	values := make(map[string]string, len(e.dynamic))
+	for k, v := range e.dynamic {
+		values[k] = strings.Replace(v, SeqPlaceholder, strconv.FormatUint(seq, 10), -1)
+	}
+	return values
+}
+
+// dynamicFields returns the dynamic context values evaluated for the event of
+// sequence number seq as fields sorted by key.
+func (e *Event) dynamicFields(seq uint64) []field {
+	if len(e.dynamic) == 0 {
+		return nil
+	}
+	values := e.dynamicValues(seq)
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	fields := make([]field, 0, len(keys))
+	for _, k := range keys {
+		value := values[k]
+		switch {
+		case e.dynamic[k] == SeqPlaceholder:
+		case e.logfmt:
+			value = string(appendLogfmtValue(nil, value))
+		default:
+			b, _ := json.Marshal(value)
+			value = string(b)
+		}
+		fields = append(fields, field{k, value})
+	}
+	return fields
+}
+
+// dynamicMembers returns the dynamic fields as JSON object members.
+func (e *Event) dynamicMembers(seq uint64) []member {
+	fields := e.dynamicFields(seq)
+	members := make([]member, 0, len(fields))
+	for _, f := range fields {
+		k, _ := json.Marshal(f.key)
+		raw := append(append(k, ':'), f.value...)
+		members = append(members, member{f.key, raw, raw[len(k)+1:]})
+	}
+	return members
+}
+
+// templateContext returns the context of the event for templates, with the
+// dynamic values evaluated.
+func (e *Event) templateContext(seq uint64) map[string]string {
+	if len(e.dynamic) == 0 {
+		return e.context
+	}
+	context := make(map[string]string, len(e.context)+len(e.dynamic))
+	for k, v := range e.context {
+		context[k] = v
+	}
+	for k, v := range e.dynamicValues(seq) {
+		context[k] = v
+	}
+	return context
+}
@@ -0,0 +1,47 @@
+package event
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDynamicContext(t *testing.T) {
+	ctx := map[string]string{"app": "x", "seq": SeqPlaceholder, "id": "run-" + SeqPlaceholder}
+	tests := []struct {
+		name    string
+		options []Option
+		inputs  []string
+		output  string
+	}{
+		{"json", []Option{JSONOutput("message", ctx)}, []string{"a", "b"},
+			`{"id":"run-1","seq":1,"app":"x","message":"a"}` + "\n" +
+				`{"id":"run-2","seq":2,"app":"x","message":"b"}` + "\n"},
+		{"logfmt", []Option{Logfmt("msg", ctx)}, []string{"a", "b"},
+			`id=run-1 seq=1 app=x msg="a"` + "\n" +
+				`id=run-2 seq=2 app=x msg="b"` + "\n"},
+		{"json input", []Option{AllowJSON(true, ctx), JSONOutput("message", ctx)}, []string{`{"m":1}`, "b"},
+			`{"app":"x","id":"run-1","seq":1,"m":1}` + "\n" +
+				`{"id":"run-2","seq":2,"app":"x","message":"b"}` + "\n"},
+		{"template", []Option{Template("{{.Context.seq}} {{.Context.app}} {{.Message}}", ctx)}, []string{"a", "b"},
+			"1 x a\n2 x b\n"},
+		{"max len", []Option{MaxLen(60), JSONOutput("message", ctx)}, []string{"abcdefghijklmnopqrstuvwxyz"},
+			`{"id":"run-1","seq":1,"app":"x","message":"abcdefg[19]..."}` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			e, err := New(out, tt.options...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer e.Close()
+			for _, input := range tt.inputs {
+				e.Write([]byte(input))
+				e.Flush()
+			}
+			if got, want := out.String(), tt.output; got != want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package event
+
+import "unicode/utf8"
+
+const hex = "0123456789abcdef"
+
+// appendEscaped appends the first char of p to dst, escaped to be embedded in
+// a JSON string as specified by RFC 8259, and returns the number of bytes of
+// p consumed. Invalid UTF-8 bytes are replaced by an escaped U+FFFD, or kept
+// as \u00XX escapes with the EscapeInvalidUTF8 option. If p starts with an
+// incomplete UTF-8 sequence and final is false, 0 is returned so the sequence
+// can be completed by the next write.
+func (e *Event) appendEscaped(dst, p []byte, final bool) ([]byte, int) {
+	b := p[0]
+	if b < utf8.RuneSelf {
+		switch b {
+		case '"', '\\':
+			return append(dst, '\\', b), 1
+		case '\b':
+			return append(dst, `\b`...), 1
+		case '\f':
+			return append(dst, `\f`...), 1
+		case '\n':
+			return append(dst, `\n`...), 1
+		case '\r':
+			return append(dst, `\r`...), 1
+		case '\t':
+			return append(dst, `\t`...), 1
+		}
+		if b < 0x20 {
+			return append(dst, '\\', 'u', '0', '0', hex[b>>4], hex[b&0xf]), 1
+		}
+		return append(dst, b), 1
+	}
+	r, size := utf8.DecodeRune(p)
+	if r == utf8.RuneError && size == 1 {
+		if !final && !utf8.FullRune(p) {
+			return dst, 0
+		}
+		if e.escInvalid {
+			return append(dst, '\\', 'u', '0', '0', hex[b>>4], hex[b&0xf]), 1
+		}
+		return append(dst, `\ufffd`...), 1
+	}
+	if e.escLineSep && (r == '\u2028' || r == '\u2029') {
+		return append(dst, '\\', 'u', '2', '0', '2', hex[r&0xf]), size
+	}
+	return append(dst, p[:size]...), size
+}
+
+// escape returns s escaped to be embedded in a JSON string.
+func (e *Event) escape(s []byte) []byte {
+	dst := make([]byte, 0, len(s))
+	for i := 0; i < len(s); {
+		var size int
+		dst, size = e.appendEscaped(dst, s[i:], true)
+		i += size
+	}
+	return dst
+}
+
+// unitLen returns the length of the escaped char starting msg and the length
+// of the input it was escaped from.
+func unitLen(msg []byte) (escaped, raw int) {
+	if msg[0] == '\\' {
+		if len(msg) >= 6 && msg[1] == 'u' {
+			if msg[2] == '2' && msg[3] == '0' && msg[4] == '2' && (msg[5] == '8' || msg[5] == '9') {
+				// escaped U+2028 or U+2029
+				return 6, 3
+			}
+			// escaped control char or invalid byte
+			return 6, 1
+		}
+		return 2, 1
+	}
+	_, size := utf8.DecodeRune(msg)
+	return size, size
+}
@@ -0,0 +1,98 @@
+package event
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+)
+
+func TestWriteEscapeRFC8259(t *testing.T) {
+	tests := []struct {
+		input   []string
+		options []Option
+		want    string
+	}{
+		{[]string{"\x1b[31mred\x1b[0m"}, nil, `\u001b[31mred\u001b[0m`},
+		{[]string{"\x00\x1f\x7f"}, nil, "\\u0000\\u001f\x7f"},
+		{[]string{"a\xffb"}, nil, `a\ufffdb`},
+		{[]string{"a\xffb"}, []Option{EscapeInvalidUTF8(true)}, `a\u00ffb`},
+		{[]string{"\xc3\xa9t\xc3\xa9"}, nil, "été"},
+		{[]string{"\xc3", "\xa9"}, nil, "é"},
+		{[]string{"\xc3", "x"}, nil, `\ufffdx`},
+		{[]string{"a\u2028b\u2029"}, nil, "a\u2028b\u2029"},
+		{[]string{"a\u2028b\u2029"}, []Option{EscapeLineTerminators(true)}, `a\u2028b\u2029`},
+	}
+	for _, tt := range tests {
+		e, _ := New(io.Discard, tt.options...)
+		for _, in := range tt.input {
+			e.Write([]byte(in))
+		}
+		if got := e.buf.String(); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.input, got, tt.want)
+		}
+		e.Close()
+	}
+}
+
+func TestFlushPendingUTF8(t *testing.T) {
+	out := &bytes.Buffer{}
+	e, _ := New(out)
+	defer e.Close()
+	e.Write([]byte("a\xe2\x82"))
+	e.Flush()
+	if got, want := out.String(), "a\\ufffd\\ufffd\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFlushMaxLenEscapes(t *testing.T) {
+	tests := []struct {
+		maxLen int
+		input  string
+		output string
+	}{
+		{10, "\x1b\x1b\x1b\x1b\x1b", "[5]...\n"},
+		{14, "\x1b\x1b\x1b\x1b\x1b", "\\u001b[4]...\n"},
+		{12, "ab\xff\xffcdefgh", "ab[8]...\n"},
+		{12, "abc\u2028def\u2028ghi", "abc[12]...\n"},
+		{12, "été été été", "ét[14]...\n"},
+	}
+	for _, tt := range tests {
+		out := &bytes.Buffer{}
+		e, _ := New(out, MaxLen(tt.maxLen))
+		e.Write([]byte(tt.input))
+		e.Flush()
+		e.Close()
+		if got := out.String(); got != tt.output {
+			t.Errorf("%q: got %q, want %q", tt.input, got, tt.output)
+		}
+		if out.Len() > tt.maxLen {
+			t.Errorf("%q: got len %d > %d", tt.input, out.Len(), tt.maxLen)
+		}
+	}
+}
+
+func TestFlushJSONValid(t *testing.T) {
+	var input []byte
+	for i := 0; i < 256; i++ {
+		input = append(input, byte(i))
+	}
+	input = append(input, "\u2028\u2029\xe2\x82"...)
+	for maxLen := 0; maxLen < 300; maxLen += 7 {
+		out := &bytes.Buffer{}
+		e, err := New(out, MaxLen(maxLen), JSONOutput("message", nil))
+		if err != nil {
+			continue
+		}
+		e.Write(input)
+		e.Flush()
+		e.Close()
+		if !json.Valid(out.Bytes()) {
+			t.Errorf("max len %d: invalid JSON %q", maxLen, out.String())
+		}
+		if maxLen > 0 && out.Len() > maxLen {
+			t.Errorf("max len %d: got len %d", maxLen, out.Len())
+		}
+	}
+}
@@ -8,32 +8,82 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
-	"math"
+	"sort"
 	"strconv"
+	"text/template"
 	"time"
+
+	"github.com/rs/golp/internal/diag"
 )
 
 // Event holds a buffer of a log event content.
 type Event struct {
-	out        *bufio.Writer
-	buf        *bytes.Buffer
-	wbuf       []byte
-	maxLen     int
-	exceeded   int
-	allowJSON  bool
-	prefix     []byte
-	suffix     []byte
-	isJSON     bool
-	jsonPrefix []byte
-	jsonSuffix []byte
-	timePrefix []byte
-	timeFormat string
-	write      chan func()
-	flush      chan chan bool
-	start      chan (<-chan time.Time) // timer
-	stop       chan bool
-	close      chan bool
+	out      *bufio.Writer
+	buf      *bytes.Buffer
+	wbuf     []byte
+	maxLen   int
+	exceeded int
+	// exceededRunes is exceeded counted in runes
+	exceededRunes int
+	// tail is the end of the message with bounded strategies and skipped
+	// the bytes dropped between buf and tail (skippedRunes in runes)
+	tail         []byte
+	skipped      int
+	skippedRunes int
+	countRunes   bool
+	truncFields  bool
+	strategy     Strategy
+	maxSize      int
+	maxLines     int
+	maxAge       time.Duration
+	// size, lines and started are the input size, the number of new lines
+	// and the start time of the event, used to enforce limits. held is the
+	// incomplete UTF-8 sequence ending the last limited write and flushing
+	// is true while the event is written to the output.
+	size        int
+	lines       int
+	started     time.Time
+	held        []byte
+	flushing    bool
+	flushReason string
+	continued   bool
+	extra       []field
+	attrs       []field
+	allowJSON   bool
+	prefix      []byte
+	suffix      []byte
+	// messageKey is the key of the message and ctxFields the context fields
+	// of the prefix, used to write the prefix without the context keys
+	// replaced by attrs
+	messageKey     string
+	ctxFields      []field
+	isJSON         bool
+	jsonContext    map[string]string
+	jsonMerge      string
+	jsonNestKey    string
+	jsonTruncField string
+	typedContext   map[string]json.RawMessage
+	// dynamic are the context values evaluated for each event and seq the
+	// sequence number of the last event
+	dynamic       map[string]string
+	seq           uint64
+	nestedContext bool
+	jsonSuffix    []byte
+	timePrefix    []byte
+	timeFormat    string
+	logfmt        bool
+	pending       []byte
+	escInvalid    bool
+	escLineSep    bool
+	tmpl          *template.Template
+	context       map[string]string
+	kind          string
+	fields        map[string]string
+	write         chan func()
+	flush         chan chan bool
+	start         chan (<-chan time.Time) // timer
+	stop          chan bool
+	close         chan bool
 }
 
 // TimestampFunc is called to generate timestamps.
@@ -57,18 +107,23 @@ func New(out io.Writer, options ...Option) (e *Event, err error) {
 		close:      make(chan bool, 1),
 		jsonSuffix: []byte("\n"),
 		suffix:     []byte("\n"),
+		strategy:   KeepHead,
+		jsonMerge:  MergeInput,
 	}
 	for _, option := range options {
 		if err := option(e); err != nil {
 			return nil, err
 		}
 	}
+	if e.tmpl != nil && e.strategy != KeepHead {
+		return nil, errors.New("truncation strategy not supported with template")
+	}
 	if e.maxLen > 0 {
-		minPayload := len(e.prefix) + len(e.suffix)
-		if len(e.timePrefix) > 0 {
-			minPayload += len(e.timePrefix) + len(e.timeFormat)
-		}
-		if e.maxLen < minPayload {
+		// A continued event has the longest limit fields
+		e.continued = true
+		overhead := e.overhead()
+		e.continued = false
+		if e.maxLen < overhead {
 			return nil, errors.New("max len is lower than JSON envelope")
 		}
 	}
@@ -76,22 +131,15 @@ func New(out io.Writer, options ...Option) (e *Event, err error) {
 	return
 }
 
-// AllowJSON allows JSON input. When this option is true and the input is JSON,
-// the maxlen option can not be enforced.
+// AllowJSON allows JSON input. When this option is true and the input is a
+// valid JSON object, the context is merged into it using the JSONMerge policy.
+// With MaxLen, string values are truncated until the object fits (see
+// JSONTruncateField). Invalid JSON input, or JSON input which can't fit, is
+// handled as a message.
 func AllowJSON(enabled bool, context map[string]string) Option {
 	return func(e *Event) error {
 		e.allowJSON = enabled
-		if len(context) > 0 {
-			ctxJSON, err := json.Marshal(context)
-			if err != nil {
-				return err
-			}
-			// Prepare for embedding by removing { } and append a comma
-			ctxJSON[len(ctxJSON)-1] = ','
-			e.jsonPrefix = ctxJSON // store {ctx, for insertion when input is already JSON
-		} else {
-			e.jsonPrefix = []byte{'{'}
-		}
+		e.jsonContext = e.dynamicContext(context)
 		return nil
 	}
 }
@@ -104,33 +152,71 @@ func JSONOutput(messageKey string, context map[string]string) Option {
 		if messageKey == "" {
 			messageKey = "msg"
 		}
+		context = e.dynamicContext(context)
 		var ctxJSON []byte
 		if len(context) > 0 {
-			ctxJSON, err = json.Marshal(context)
-			if err != nil {
-				return
-			}
+			ctxJSON = appendObject(nil, e.contextMembers(context))
 			// Prepare for embedding by removing { } and append a comma
 			ctxJSON[len(ctxJSON)-1] = ','
 			ctxJSON = ctxJSON[1:]
 		}
 		e.prefix = []byte(fmt.Sprintf(`{%s"%s":"`, ctxJSON, messageKey))
+		e.messageKey = messageKey
+		for _, m := range e.contextMembers(context) {
+			e.ctxFields = append(e.ctxFields, field{m.key, string(m.value)})
+		}
 		e.suffix = []byte("\"}\n")
 		return
 	}
 }
 
+// Logfmt makes the event output formatted as logfmt. The context is written
+// as key=value pairs followed by the content of the message as the
+// messageKey key.
+func Logfmt(messageKey string, context map[string]string) Option {
+	return func(e *Event) error {
+		if messageKey == "" {
+			messageKey = "msg"
+		}
+		context = e.dynamicContext(context)
+		keys := make([]string, 0, len(context))
+		for k := range context {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var prefix []byte
+		for _, k := range keys {
+			prefix = appendLogfmtKey(prefix, k)
+			prefix = append(prefix, '=')
+			prefix = appendLogfmtValue(prefix, context[k])
+			prefix = append(prefix, ' ')
+			e.ctxFields = append(e.ctxFields, field{k, string(appendLogfmtValue(nil, context[k]))})
+		}
+		prefix = appendLogfmtKey(prefix, messageKey)
+		e.prefix = append(prefix, '=', '"')
+		e.messageKey = messageKey
+		e.suffix = []byte("\"\n")
+		e.logfmt = true
+		return nil
+	}
+}
+
 // AddTimestamp adds a timestamp to each event using the provided format.
-// If the output is json, the value is added to the jsonKey key.
+// If the output is json, the value is added to the jsonKey key. If the output
+// is logfmt, the key/value pair is added first.
 // If JSON input is allowed and input is JSON, no timestamp is added.
-// JSONOutput must be used before this option.
+// JSONOutput or Logfmt must be used before this option.
 func AddTimestamp(jsonKey, format string) Option {
 	return func(e *Event) error {
 		if len(e.prefix) == 0 {
 			return errors.New("AddTimestamp used before JSONOutput")
 		}
-		e.timePrefix = []byte(fmt.Sprintf(`","%s":`, jsonKey))
 		e.timeFormat = format
+		if e.logfmt {
+			e.timePrefix = append(appendLogfmtKey(nil, jsonKey), '=')
+			return nil
+		}
+		e.timePrefix = []byte(fmt.Sprintf(`","%s":`, jsonKey))
 		e.suffix = []byte("}\n")
 		return nil
 	}
@@ -145,9 +231,36 @@ func MaxLen(maxLen int) Option {
 	}
 }
 
+// EscapeInvalidUTF8 keeps invalid UTF-8 bytes as \u00XX escapes instead of
+// replacing them by the U+FFFD replacement character.
+func EscapeInvalidUTF8(enabled bool) Option {
+	return func(e *Event) error {
+		e.escInvalid = enabled
+		return nil
+	}
+}
+
+// EscapeLineTerminators escapes the U+2028 and U+2029 line terminators so the
+// output can be embedded in JavaScript.
+func EscapeLineTerminators(enabled bool) Option {
+	return func(e *Event) error {
+		e.escLineSep = enabled
+		return nil
+	}
+}
+
 // Empty returns true if the event's buffer is empty.
 func (e *Event) Empty() bool {
-	return e.buf.Len() == 0
+	done := make(chan bool)
+	// Read the buffer from the write loop as an auto-flush may reset it
+	e.write <- (func() {
+		done <- e.empty()
+	})
+	return <-done
+}
+
+func (e *Event) empty() bool {
+	return e.buf.Len() == 0 && len(e.pending) == 0 && len(e.held) == 0
 }
 
 // Write appends the contents of p to the buffer. The return value
@@ -162,57 +275,61 @@ func (e *Event) Write(p []byte) (n int, err error) {
 	return
 }
 
-// isJSON returns true if b *seems* to contain a JSON object
-func isJSON(b []byte) bool {
-	if len(b) < 2 {
-		return false
-	}
-	return b[0] == '{' && b[1] == '"'
+// IsJSON returns true if b *seems* to contain a JSON object, i.e. if it would
+// be written as is with the AllowJSON option.
+func IsJSON(b []byte) bool {
+	return len(b) >= 2 && b[0] == '{'
 }
 
 func (e *Event) doWrite(p []byte) (n int, err error) {
-	if e.allowJSON && !e.isJSON && e.buf.Len() == 0 {
-		// Check if the line start as a JSON object.
-		// If JSON, insert the context and write directly to the output.
-		e.isJSON = isJSON(p)
-		if e.isJSON {
-			e.out.Write(e.jsonPrefix)
-			n, err = e.out.Write(p[1:]) // skip the {
-			return n + 1, err
+	if e.allowJSON && !e.isJSON && e.empty() && IsJSON(p) {
+		// If the line is a JSON object, merge the context and write it
+		// directly to the output.
+		if e.isJSON = e.writeJSON(p); e.isJSON {
+			return len(p), nil
 		}
 	}
 	if e.isJSON {
 		// Input is already JSON, do not escape or compute exceeding
 		return e.out.Write(p)
 	}
+	if e.limited() {
+		return e.writeLimited(p)
+	}
+	return e.writeBuffer(p)
+}
+
+// writeBuffer appends p to the buffer, escaped or raw.
+func (e *Event) writeBuffer(p []byte) (n int, err error) {
+	if e.rawBuffer() {
+		return e.doWriteRaw(p)
+	}
+	return e.writeEscaped(p, false)
+}
+
+// writeEscaped escapes p into the buffer until max len is reached. Unless
+// final is true, an incomplete UTF-8 sequence at the end of p is kept pending
+// until the next write.
+func (e *Event) writeEscaped(p []byte, final bool) (n int, err error) {
+	if len(e.pending) > 0 {
+		p = append(e.pending, p...)
+		e.pending = nil
+	}
 	if e.exceeded > 0 {
-		e.exceeded += len(p)
+		e.addExceeded(p)
 		return
 	}
-	overhead := len(e.prefix) + len(e.suffix)
+	overhead := e.overhead()
 	e.buf.Grow(len(p))
-	for i, b := range p {
-		e.wbuf = e.wbuf[:0]
-		switch b {
-		case '"':
-			e.wbuf = append(e.wbuf, '\\', b)
-		case '\\':
-			e.wbuf = append(e.wbuf, `\\`...)
-		case '\b':
-			e.wbuf = append(e.wbuf, `\b`...)
-		case '\f':
-			e.wbuf = append(e.wbuf, `\f`...)
-		case '\n':
-			e.wbuf = append(e.wbuf, `\n`...)
-		case '\r':
-			e.wbuf = append(e.wbuf, `\r`...)
-		case '\t':
-			e.wbuf = append(e.wbuf, `\t`...)
-		default:
-			e.wbuf = append(e.wbuf, b)
+	for i := 0; i < len(p); {
+		var size int
+		e.wbuf, size = e.appendEscaped(e.wbuf[:0], p[i:], final)
+		if size == 0 {
+			e.pending = append([]byte{}, p[i:]...)
+			break
 		}
 		if e.maxLen > 0 && e.buf.Len()+overhead+len(e.wbuf) > e.maxLen {
-			e.exceeded = len(p) - i
+			e.addExceeded(p[i:])
 			break
 		}
 		var _n int
@@ -221,19 +338,35 @@ func (e *Event) doWrite(p []byte) (n int, err error) {
 		if err != nil {
 			break
 		}
+		i += size
 	}
 	return
 }
 
+// overhead returns the number of bytes added around the message.
+func (e *Event) overhead() int {
+	n := len(e.prefix) + len(e.suffix)
+	if len(e.timePrefix) > 0 {
+		// The formatted time is quoted in JSON and followed by a space in
+		// logfmt. The length of the format is used as an estimation of the
+		// length of the formatted time.
+		n += len(e.timePrefix) + len(e.timeFormat) + 2
+	}
+	if len(e.dynamic) > 0 {
+		n += len(e.appendFields(nil, e.dynamicFields(e.seq+1)))
+	}
+	if len(e.attrs) > 0 {
+		n += len(e.appendFields(nil, e.attrs))
+	}
+	return n + e.limitFieldsLen()
+}
+
 // Flush appends the eol string to the buffer and copies it to the
 // output writer. The buffer is reset after this operation so the
 // event can be reused.
 //
 // If an AutoFlush was in progress, it is stopped by this operation.
 func (e *Event) Flush() {
-	if e.buf.Len() == 0 && !e.isJSON {
-		return
-	}
 	c := make(chan bool)
 	// Make the flushLoop to flush
 	e.flush <- c
@@ -241,14 +374,6 @@ func (e *Event) Flush() {
 	<-c
 }
 
-// uintLen return the number of chars taken by an integer
-func uintLen(i uint) (l int) {
-	if i == 0 {
-		return 1
-	}
-	return int(math.Log10(float64(i))) + 1
-}
-
 func (e *Event) doFlush() {
 	defer func() {
 		if err := e.out.Flush(); err != nil {
@@ -257,61 +382,89 @@ func (e *Event) doFlush() {
 	}()
 	if e.isJSON {
 		e.isJSON = false
+		e.continued = false
 		if _, err := e.out.Write(e.jsonSuffix); err != nil {
 			logWriteErr(err)
 		}
 		return
 	}
-	if e.buf.Len() == 0 {
+	if len(e.held) > 0 {
+		held := e.held
+		e.held = nil
+		if _, err := e.writeBuffer(held); err != nil {
+			logWriteErr(err)
+		}
+	}
+	if len(e.pending) > 0 && !e.rawBuffer() {
+		// Flush the incomplete UTF-8 sequence as invalid bytes
+		e.writeEscaped(nil, true)
+	}
+	e.flushing = true
+	if e.buf.Len() == 0 && (e.rawBuffer() || !e.markerFits()) {
+		// Nothing to write, or nothing fit and neither does the marker
+		e.flushing = false
+		return
+	}
+	defer e.reset()
+	e.seq++
+	if e.tmpl != nil {
+		e.doFlushTemplate()
 		return
 	}
-	if len(e.prefix) > 0 {
-		if _, err := e.out.Write(e.prefix); err != nil {
+	if e.logfmt || len(e.prefix) > 0 {
+		e.extra = append(e.extra, e.attrs...)
+		e.extra = append(e.extra, e.withoutAttrs(e.dynamicFields(e.seq))...)
+		e.extra = append(e.extra, e.limitFields(e.continued, e.flushReason)...)
+	}
+	if e.skipped == 0 && len(e.tail) > 0 {
+		// Nothing was dropped, the message is contiguous
+		e.buf.Write(e.tail)
+		e.tail = e.tail[:0]
+	}
+	msg := e.buf.Bytes()
+	if e.strategy == Split && e.rawBuffer() {
+		if id, chunks := e.splitMessage(msg); chunks != nil {
+			e.writeChunks(id, chunks)
+			return
+		}
+	}
+	if e.rawBuffer() {
+		msg = e.shrinkMessage(msg)
+	} else if e.exceeded > 0 {
+		// Insert [total_bytes_truncated]... at the end of the message if possible
+		msg = e.truncateMessage(msg)
+	}
+	e.writeRecord(msg)
+}
+
+// writeRecord writes the escaped msg with its envelope and the extra fields.
+func (e *Event) writeRecord(msg []byte) {
+	if e.logfmt && len(e.timePrefix) > 0 {
+		ts := append([]byte{}, e.timePrefix...)
+		ts = appendLogfmtValue(ts, TimestampFunc().Format(e.timeFormat))
+		if _, err := e.out.Write(append(ts, ' ')); err != nil {
 			logWriteErr(err)
 		}
 	}
-	const elipse = "[]..."
-	if e.exceeded > 0 && e.buf.Len() > len(elipse)+1 {
-		// Insert [total_bytes_truncated]… at the end of the message if possible
-		msg := e.buf.Bytes()
-		// estimate truncated byte number including the marker
-		t := e.exceeded + len(elipse)
-		t += uintLen(uint(t))
-		if pos := len(msg) - (t - e.exceeded); pos > 0 {
-			// Ensure we don't cut in the middle of an escaped char by
-			// searching for the first \ of a continuous sequence of \
-			// and consider removing the current one if is not an escaped
-			// char itself
-			escapes := 0
-			for pos-escapes > 0 && msg[pos-escapes] == '\\' {
-				escapes++
-			}
-			if escapes > 0 {
-				pos -= (escapes + 1) % 2
-			}
-			// Compute the actual truncated bytes before escaping
-			t := e.exceeded
-			for i := pos; i < len(msg); i++ {
-				if msg[i] == '\\' {
-					// Skip escaped char from the count
-					i++
-				}
-				t++
+	if len(e.prefix) > 0 {
+		prefix := e.recordPrefix()
+		if len(e.extra) > 0 {
+			// Insert extra fields at the beginning of the JSON object or
+			// before the logfmt prefix
+			if e.logfmt {
+				prefix = append(e.appendFields(nil, e.extra), prefix...)
+			} else {
+				prefix = append(e.appendFields([]byte{'{'}, e.extra), prefix[1:]...)
 			}
-			eb := strconv.FormatInt(int64(t), 10)
-			msg = append(msg[:pos], elipse[0])
-			msg = append(msg, eb...)
-			msg = append(msg, elipse[1:]...)
-		}
-		if _, err := e.out.Write(msg); err != nil {
-			logWriteErr(err)
 		}
-	} else {
-		if _, err := io.Copy(e.out, e.buf); err != nil {
+		if _, err := e.out.Write(prefix); err != nil {
 			logWriteErr(err)
 		}
 	}
-	if len(e.timePrefix) > 0 {
+	if _, err := e.out.Write(msg); err != nil {
+		logWriteErr(err)
+	}
+	if !e.logfmt && len(e.timePrefix) > 0 {
 		if _, err := e.out.Write(e.timePrefix); err != nil {
 			logWriteErr(err)
 		}
@@ -325,12 +478,30 @@ func (e *Event) doFlush() {
 			logWriteErr(err)
 		}
 	}
+}
+
+// reset clears the event after a flush.
+func (e *Event) reset() {
 	e.buf.Reset()
 	e.exceeded = 0
+	e.exceededRunes = 0
+	e.tail = e.tail[:0]
+	e.skipped = 0
+	e.skippedRunes = 0
+	e.extra = e.extra[:0]
+	e.attrs = e.attrs[:0]
+	e.pending = nil
+	e.kind = ""
+	e.fields = nil
+	e.size = 0
+	e.lines = 0
+	e.flushReason = ""
+	e.continued = false
+	e.flushing = false
 }
 
 func logWriteErr(err error) {
-	log.Printf("golp: write error: %v", err)
+	diag.Printf("golp: write error: %v", err)
 }
 
 // AutoFlush schedule a flush after delay.
@@ -3,13 +3,13 @@ package event
 import (
 	"bytes"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"testing"
 	"time"
 )
 
 func TestWriteEscape(t *testing.T) {
-	e, _ := New(ioutil.Discard)
+	e, _ := New(io.Discard)
 	defer e.Close()
 	e.Write([]byte("\b\f\r\n\t\\\""))
 	if got, want := e.buf.String(), `\b\f\r\n\t\\\"`; got != want {
@@ -18,7 +18,7 @@ func TestWriteEscape(t *testing.T) {
 }
 
 func TestWriteMaxLen(t *testing.T) {
-	e, _ := New(ioutil.Discard, MaxLen(5))
+	e, _ := New(io.Discard, MaxLen(5))
 	defer e.Close()
 	n, _ := e.Write([]byte("abcdefghij"))
 	if got, want := n, 4; got != want {
@@ -93,6 +93,58 @@ func TestFlushJSONMaxLen(t *testing.T) {
 	}
 }
 
+func TestFlushLogfmt(t *testing.T) {
+	TimestampFunc = func() time.Time {
+		return time.Time{}
+	}
+	defer func() {
+		TimestampFunc = time.Now
+	}()
+	out := &bytes.Buffer{}
+	e, _ := New(out, Logfmt("msg", map[string]string{"level": "error", "a b": "c=d"}), AddTimestamp("time", time.RFC3339))
+	defer e.Close()
+	e.Write([]byte("line1 \"quoted\"\n"))
+	e.Write([]byte("line2"))
+	e.Flush()
+	if got, want := out.String(), "time=0001-01-01T00:00:00Z a_b=\"c=d\" level=error msg=\"line1 \\\"quoted\\\"\\nline2\"\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFlushLogfmtMaxLen(t *testing.T) {
+	out := &bytes.Buffer{}
+	e, _ := New(out, MaxLen(24), Logfmt("msg", nil))
+	defer e.Close()
+	e.Write([]byte("line1\n"))
+	e.Write([]byte("line2\n"))
+	e.Write([]byte("line3"))
+	e.Flush()
+	if got, want := len(out.String()), 24; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := out.String(), "msg=\"line1\\nline[7]...\"\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestLogfmtValue(t *testing.T) {
+	tests := map[string]string{
+		"simple": "simple",
+		"":       `""`,
+		"a b":    `"a b"`,
+		"a=b":    `"a=b"`,
+		`a"b`:    `"a\"b"`,
+		"a\nb":   `"a\nb"`,
+		"héhé":   "héhé",
+		"\xff":   `"\xff"`,
+	}
+	for v, want := range tests {
+		if got := string(appendLogfmtValue(nil, v)); got != want {
+			t.Errorf("appendLogfmtValue(%q): got %s, want %s", v, got, want)
+		}
+	}
+}
+
 func TestFlushEmpty(t *testing.T) {
 	out := &bytes.Buffer{}
 	e, _ := New(out)
@@ -123,7 +175,7 @@ func TestAllowJSON(t *testing.T) {
 }
 
 func TestEmpty(t *testing.T) {
-	e, _ := New(ioutil.Discard)
+	e, _ := New(io.Discard)
 	defer e.Close()
 	if got, want := e.Empty(), true; got != want {
 		t.Errorf("got %v, want %v", got, want)
@@ -0,0 +1,240 @@
+package event
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Policies merging the context into JSON input objects.
+const (
+	// MergeInput keeps the input value of keys found in both the context and
+	// the input.
+	MergeInput = "input"
+	// MergeContext keeps the context value of keys found in both the context
+	// and the input.
+	MergeContext = "context"
+	// MergeNest adds the context as an object under a key, replacing the
+	// input value of this key if any.
+	MergeNest = "nest"
+)
+
+// member is a member of a JSON object with its raw bytes as read.
+type member struct {
+	key string
+	// raw is the member as read, value is the raw value in raw
+	raw   []byte
+	value []byte
+}
+
+// JSONMerge sets the policy merging the context into JSON input objects
+// allowed by AllowJSON. With MergeNest, the context is added under nestKey.
+// Default policy is MergeInput.
+func JSONMerge(policy, nestKey string) Option {
+	return func(e *Event) error {
+		switch policy {
+		case "":
+			policy = MergeInput
+		case MergeInput, MergeContext:
+		case MergeNest:
+			if nestKey == "" {
+				return errors.New("missing key to nest the context under")
+			}
+		default:
+			return fmt.Errorf("invalid JSON merge policy: %s", policy)
+		}
+		e.jsonMerge = policy
+		e.jsonNestKey = nestKey
+		return nil
+	}
+}
+
+// JSONTruncateField sets the string field of JSON input objects truncated
+// first when they exceed max len. If truncating this field is not enough or if
+// key is empty, the longest string values are truncated.
+func JSONTruncateField(key string) Option {
+	return func(e *Event) error {
+		e.jsonTruncField = key
+		return nil
+	}
+}
+
+// parseObject parses b as a single JSON object and returns its members. The
+// raw bytes of the members are kept as read.
+func parseObject(b []byte) ([]member, error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	if t, err := d.Token(); err != nil || t != json.Delim('{') {
+		return nil, errors.New("not a JSON object")
+	}
+	var members []member
+	for d.More() {
+		start := d.InputOffset()
+		t, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := t.(string)
+		valueStart := d.InputOffset()
+		var v json.RawMessage
+		if err := d.Decode(&v); err != nil {
+			return nil, err
+		}
+		raw := bytes.TrimLeft(b[start:d.InputOffset()], " \t\r\n,")
+		value := bytes.TrimLeft(b[valueStart:d.InputOffset()], " \t\r\n:")
+		members = append(members, member{key, raw, value})
+	}
+	if _, err := d.Token(); err != nil {
+		return nil, err
+	}
+	if _, err := d.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after JSON object")
+	}
+	return members, nil
+}
+
+// newMember returns a member with the key and the JSON encoded value.
+func newMember(key string, value interface{}) member {
+	k, _ := json.Marshal(key)
+	v, _ := json.Marshal(value)
+	raw := append(append(k, ':'), v...)
+	return member{key, raw, raw[len(k)+1:]}
+}
+
+// mergeContext merges the context into the input members using the merge
+// policy.
+func (e *Event) mergeContext(input []member) []member {
+	context := append(e.contextMembers(e.jsonContext), e.dynamicMembers(e.seq)...)
+	if len(context) == 0 {
+		return input
+	}
+	if e.jsonMerge == MergeNest {
+		k, _ := json.Marshal(e.jsonNestKey)
+		raw := appendObject(append(k, ':'), context)
+		members := []member{{e.jsonNestKey, raw, raw[len(k)+1:]}}
+		for _, m := range input {
+			if m.key != e.jsonNestKey {
+				members = append(members, m)
+			}
+		}
+		return members
+	}
+	inInput := make(map[string]bool, len(input))
+	for _, m := range input {
+		inInput[m.key] = true
+	}
+	inContext := make(map[string]bool, len(context))
+	members := make([]member, 0, len(context)+len(input))
+	for _, m := range context {
+		inContext[m.key] = true
+		if e.jsonMerge == MergeContext || !inInput[m.key] {
+			members = append(members, m)
+		}
+	}
+	for _, m := range input {
+		if inContext[m.key] && e.jsonMerge == MergeContext {
+			continue
+		}
+		members = append(members, m)
+	}
+	return members
+}
+
+// appendObject appends the members as a JSON object to dst.
+func appendObject(dst []byte, members []member) []byte {
+	dst = append(dst, '{')
+	for i, m := range members {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = append(dst, m.raw...)
+	}
+	return append(dst, '}')
+}
+
+// writeJSON writes p, a JSON object, to the output with the context merged.
+// It returns false if p is not a valid JSON object.
+func (e *Event) writeJSON(p []byte) bool {
+	members, err := parseObject(p)
+	if err != nil {
+		return false
+	}
+	e.seq++
+	obj := appendObject(nil, e.mergeContext(members))
+	if e.maxLen > 0 && len(obj)+len(e.jsonSuffix) > e.maxLen {
+		if obj = e.shrinkObject(e.mergeContext(members)); obj == nil {
+			// The line is handled as a message with its own sequence number
+			e.seq--
+			return false
+		}
+	}
+	if _, err := e.out.Write(obj); err != nil {
+		logWriteErr(err)
+	}
+	return true
+}
+
+// shrinkObject truncates the string values of members with a [N]... marker
+// and adds a truncated field until the object fits in max len. It returns nil
+// if the object can't fit.
+func (e *Event) shrinkObject(members []member) []byte {
+	shrunk := make([]member, 0, len(members)+1)
+	for _, m := range members {
+		if m.key != "truncated" {
+			shrunk = append(shrunk, m)
+		}
+	}
+	// origs are the members before truncation
+	origs := append([]member{}, shrunk...)
+	shrunk = append(shrunk, newMember("truncated", true))
+	// exhausted tracks the members which can't be truncated further
+	exhausted := make([]bool, len(shrunk))
+	exhausted[len(origs)] = true
+	for {
+		obj := appendObject(nil, shrunk)
+		excess := len(obj) + len(e.jsonSuffix) - e.maxLen
+		if excess <= 0 {
+			return obj
+		}
+		i := e.truncateTarget(shrunk, exhausted)
+		if i == -1 {
+			return nil
+		}
+		m, orig := shrunk[i], origs[i]
+		content := orig.value[1 : len(orig.value)-1]
+		original := escapedLen(content, e.countRunes)
+		max := len(m.value) - 2 - excess
+		t, ok := truncate(append([]byte{}, content...), original, max, e.countRunes)
+		if !ok {
+			exhausted[i] = true
+			if markerLen(original) >= len(content) {
+				continue
+			}
+			// Keep the marker only
+			t, _ = truncate(append([]byte{}, content...), original, markerLen(original), e.countRunes)
+		}
+		raw := append([]byte{}, m.raw[:len(m.raw)-len(m.value)]...)
+		raw = append(append(append(raw, '"'), t...), '"')
+		shrunk[i] = member{m.key, raw, raw[len(raw)-len(t)-2:]}
+	}
+}
+
+// truncateTarget returns the index of the member to truncate next: the
+// JSONTruncateField field if any, or the member with the longest string
+// value. It returns -1 if no member can be truncated.
+func (e *Event) truncateTarget(members []member, exhausted []bool) int {
+	target := -1
+	for i, m := range members {
+		if exhausted[i] || len(m.value) < 2 || m.value[0] != '"' {
+			continue
+		}
+		if e.jsonTruncField != "" && m.key == e.jsonTruncField {
+			return i
+		}
+		if target == -1 || len(m.value) > len(members[target].value) {
+			target = i
+		}
+	}
+	return target
+}
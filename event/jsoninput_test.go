@@ -0,0 +1,89 @@
+package event
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestJSONMerge(t *testing.T) {
+	ctx := map[string]string{"foo": "bar", "level": "error"}
+	tests := []struct {
+		name    string
+		options []Option
+		input   string
+		output  string
+	}{
+		{"no context", []Option{AllowJSON(true, nil)}, `{"msg": "a json message","n": 1}`, `{"msg": "a json message","n": 1}`},
+		{"input", []Option{AllowJSON(true, ctx)}, `{"level":"info", "msg":"x"}`, `{"foo":"bar","level":"info","msg":"x"}`},
+		{"context", []Option{AllowJSON(true, ctx), JSONMerge(MergeContext, "")}, `{"level":"info", "msg":"x"}`, `{"foo":"bar","level":"error","msg":"x"}`},
+		{"nest", []Option{AllowJSON(true, ctx), JSONMerge(MergeNest, "ctx")}, `{"ctx":1,"msg":"x"}`, `{"ctx":{"foo":"bar","level":"error"},"msg":"x"}`},
+		{"empty object", []Option{AllowJSON(true, ctx)}, `{}`, `{"foo":"bar","level":"error"}`},
+		{"nested values", []Option{AllowJSON(true, ctx)}, `{"a":{"b":[1,{"c":"d"}]} , "e":null}`, `{"foo":"bar","level":"error","a":{"b":[1,{"c":"d"}]},"e":null}`},
+		{"invalid", []Option{AllowJSON(true, nil)}, `{"foo":}`, `{\"foo\":}`},
+		{"trailing data", []Option{AllowJSON(true, nil)}, `{"foo":1} {"bar":2}`, `{\"foo\":1} {\"bar\":2}`},
+		{"invalid json output", []Option{AllowJSON(true, ctx), JSONOutput("message", ctx)}, `{"foo"`, `{"foo":"bar","level":"error","message":"{\"foo\""}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			e, err := New(out, tt.options...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer e.Close()
+			e.Write([]byte(tt.input))
+			e.Flush()
+			if got, want := out.String(), tt.output+"\n"; got != want {
+				t.Errorf("got %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestJSONMergeInvalid(t *testing.T) {
+	if _, err := New(nil, JSONMerge("bogus", "")); err == nil {
+		t.Error("got no error for invalid policy")
+	}
+	if _, err := New(nil, JSONMerge(MergeNest, "")); err == nil {
+		t.Error("got no error for missing nest key")
+	}
+}
+
+func TestJSONMaxLen(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []Option
+		input   string
+		output  string
+	}{
+		{"fits", []Option{MaxLen(30)}, `{"message":"abcdefghij"}`, `{"message":"abcdefghij"}`},
+		{"longest", []Option{MaxLen(100)}, `{"message":"abcdefghijklmnopqrstuvwxyzabcdefghijklmnopqrstuvwxyz","level":"error","stack":"0123456789"}`,
+			`{"message":"abcdefghijklmnopqrstuvwx[28]...","level":"error","stack":"0123456789","truncated":true}`},
+		{"field", []Option{MaxLen(60), JSONTruncateField("stack")}, `{"message":"abcdefghij","stack":"0123456789abcdefghij0123456789"}`,
+			`{"message":"abcdefghij","stack":"[30]...","truncated":true}`},
+		{"field then longest", []Option{MaxLen(60), JSONTruncateField("stack")}, `{"message":"abcdefghijklmnopqrstuvwxyz","stack":"0123456789"}`,
+			`{"message":"abc[23]...","stack":"0[9]...","truncated":true}`},
+		{"escapes", []Option{MaxLen(40)}, `{"message":"a\nb\nc\nd\ne\nf\ng\nh\ni\nj"}`, `{"message":"a[18]...","truncated":true}`},
+		{"replaced marker", []Option{MaxLen(50)}, `{"truncated":false,"message":"abcdefghijklmnopqrstuvwxyz"}`,
+			`{"message":"abcdefghijk[15]...","truncated":true}`},
+		{"no string", []Option{MaxLen(30), JSONOutput("message", nil)}, `{"a":[1,2,3,4,5,6,7,8,9,10,11]}`, `{"message":"{\"a\":[[25]..."}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			e, err := New(out, append(tt.options, AllowJSON(true, nil))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer e.Close()
+			e.Write([]byte(tt.input))
+			e.Flush()
+			if got, want := out.String(), tt.output+"\n"; got != want {
+				t.Errorf("got %s, want %s", got, want)
+			}
+			if out.Len() > e.maxLen {
+				t.Errorf("got len %d > %d", out.Len(), e.maxLen)
+			}
+		})
+	}
+}
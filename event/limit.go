@@ -0,0 +1,173 @@
+package event
+
+import (
+	"bytes"
+	"time"
+	"unicode/utf8"
+)
+
+// Reasons reported when an event is flushed because it reached a limit.
+const (
+	LimitSize  = "size"
+	LimitLines = "lines"
+	LimitAge   = "age"
+)
+
+// MaxSize bounds the memory used by an event to maxSize input bytes. When
+// reached, the event is flushed and continued as a follow-up event. With the
+// JSONOutput or Logfmt option, the flushed event gets a flush_reason field
+// and the follow-up event a continued field.
+func MaxSize(maxSize int) Option {
+	return func(e *Event) error {
+		e.maxSize = maxSize
+		return nil
+	}
+}
+
+// MaxLines bounds an event to maxLines lines. When reached, the event is
+// flushed and continued as a follow-up event like with MaxSize.
+func MaxLines(maxLines int) Option {
+	return func(e *Event) error {
+		e.maxLines = maxLines
+		return nil
+	}
+}
+
+// MaxAge bounds the time an event is kept in memory. When an event older than
+// maxAge is written to, it is flushed and continued as a follow-up event like
+// with MaxSize.
+func MaxAge(maxAge time.Duration) Option {
+	return func(e *Event) error {
+		e.maxAge = maxAge
+		return nil
+	}
+}
+
+// limited returns true if any memory limit is set.
+func (e *Event) limited() bool {
+	return e.maxSize > 0 || e.maxLines > 0 || e.maxAge > 0
+}
+
+// writeLimited writes p to the buffer, force flushing the event each time a
+// limit is reached. The new line reaching the lines limit is not written as
+// it becomes the boundary between the events.
+func (e *Event) writeLimited(p []byte) (n int, err error) {
+	n = len(p)
+	if len(e.held) > 0 {
+		p = append(e.held, p...)
+		e.held = nil
+	}
+	// Hold an incomplete UTF-8 sequence back until the next write so a rune
+	// split across writes is not cut by the size limit
+	i := len(p) - incompleteLen(p)
+	p, tail := p[:i], p[i:]
+	for len(p) > 0 {
+		if e.size == 0 {
+			e.started = TimestampFunc()
+		} else if e.maxAge > 0 && TimestampFunc().Sub(e.started) >= e.maxAge {
+			e.forceFlush(LimitAge)
+			continue
+		}
+		chunk, reason, skip := p, "", 0
+		if e.maxLines > 0 {
+			if i := indexNewline(p, e.maxLines-e.lines); i != -1 {
+				chunk, reason, skip = p[:i], LimitLines, 1
+			}
+		}
+		if e.maxSize > 0 && e.size+len(chunk) > e.maxSize {
+			c := e.maxSize - e.size
+			for c > 0 && !utf8.RuneStart(chunk[c]) {
+				c--
+			}
+			if c == 0 && e.size == 0 {
+				// Always make progress, even with a limit lower than a char
+				_, c = utf8.DecodeRune(chunk)
+			}
+			chunk, reason, skip = chunk[:c], LimitSize, 0
+		}
+		if _, err = e.writeBuffer(chunk); err != nil {
+			return
+		}
+		e.size += len(chunk)
+		e.lines += bytes.Count(chunk, []byte{'\n'})
+		p = p[len(chunk)+skip:]
+		if reason != "" {
+			e.forceFlush(reason)
+		}
+	}
+	if len(tail) > 0 {
+		e.held = append([]byte{}, tail...)
+	}
+	return
+}
+
+// incompleteLen returns the length of the incomplete UTF-8 sequence ending p.
+func incompleteLen(p []byte) int {
+	for i := 1; i <= utf8.UTFMax && i <= len(p); i++ {
+		if utf8.RuneStart(p[len(p)-i]) {
+			if utf8.FullRune(p[len(p)-i:]) {
+				return 0
+			}
+			return i
+		}
+	}
+	return 0
+}
+
+// indexNewline returns the index of the nth new line of p or -1.
+func indexNewline(p []byte, nth int) int {
+	i := -1
+	for ; nth > 0; nth-- {
+		j := bytes.IndexByte(p[i+1:], '\n')
+		if j == -1 {
+			return -1
+		}
+		i += j + 1
+	}
+	return i
+}
+
+// forceFlush flushes the event because of the reason limit and marks the
+// next event as its continuation, with the same kind, fields and attrs.
+func (e *Event) forceFlush(reason string) {
+	kind, fields, seq := e.kind, e.fields, e.seq
+	attrs := append([]field(nil), e.attrs...)
+	continued := e.continued
+	e.flushReason = reason
+	e.doFlush()
+	// Reset the limits even when nothing fit in max len and nothing was
+	// written, so the next pass makes progress
+	e.reset()
+	e.kind, e.fields = kind, fields
+	e.attrs = append(e.attrs, attrs...)
+	e.continued = continued || e.seq != seq
+}
+
+// limitFields returns the continued and flush_reason fields.
+func (e *Event) limitFields(continued bool, reason string) (fields []field) {
+	if continued {
+		fields = append(fields, field{"continued", "true"})
+	}
+	if reason != "" {
+		value := `"` + reason + `"`
+		if e.logfmt {
+			value = reason
+		}
+		fields = append(fields, field{"flush_reason", value})
+	}
+	return
+}
+
+// limitFieldsLen returns the length of the limit fields of the event,
+// reserved in the event overhead when limits are set. Until the event is
+// flushed, its flush reason is unknown and the longest one is reserved.
+func (e *Event) limitFieldsLen() int {
+	if !e.limited() || !(e.logfmt || len(e.prefix) > 0) {
+		return 0
+	}
+	reason := e.flushReason
+	if !e.flushing {
+		reason = LimitLines
+	}
+	return len(e.appendFields(nil, e.limitFields(e.continued, reason)))
+}
@@ -0,0 +1,121 @@
+package event
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimits(t *testing.T) {
+	now := time.Date(2017, 1, 8, 16, 59, 26, 0, time.UTC)
+	TimestampFunc = func() time.Time {
+		return now
+	}
+	defer func() {
+		TimestampFunc = time.Now
+	}()
+	tests := []struct {
+		name    string
+		options []Option
+		writes  []string
+		output  string
+	}{
+		{"lines", []Option{MaxLines(2), JSONOutput("message", nil)}, []string{"a\nb\nc\nd\ne"},
+			`{"flush_reason":"lines","message":"a\nb"}` + "\n" +
+				`{"continued":true,"flush_reason":"lines","message":"c\nd"}` + "\n" +
+				`{"continued":true,"message":"e"}` + "\n"},
+		{"lines writes", []Option{MaxLines(2), Logfmt("msg", nil)}, []string{"a", "\n", "b", "\n", "c"},
+			`flush_reason=lines msg="a\nb"` + "\n" +
+				`continued=true msg="c"` + "\n"},
+		{"size", []Option{MaxSize(4), JSONOutput("message", nil)}, []string{"abcdéfgh"},
+			`{"flush_reason":"size","message":"abcd"}` + "\n" +
+				`{"continued":true,"flush_reason":"size","message":"éfg"}` + "\n" +
+				`{"continued":true,"message":"h"}` + "\n"},
+		{"size split rune", []Option{MaxSize(4), JSONOutput("message", nil)}, []string{"abc\xc3", "\xa9d"},
+			`{"flush_reason":"size","message":"abc"}` + "\n" +
+				`{"continued":true,"message":"éd"}` + "\n"},
+		{"size lower than char", []Option{MaxSize(1)}, []string{"éa"}, "é\na\n"},
+		{"age", []Option{MaxAge(time.Second), JSONOutput("message", nil)}, []string{"a", "b", "\n", "c"},
+			`{"flush_reason":"age","message":"ab"}` + "\n" +
+				`{"continued":true,"message":"\nc"}` + "\n"},
+		{"text", []Option{MaxLines(1)}, []string{"a\nb"}, "a\nb\n"},
+		{"max len", []Option{MaxLen(56), MaxLines(1), JSONOutput("message", nil)}, []string{"abcdefghijklmnopqrstuvwxyz\nk"},
+			`{"flush_reason":"lines","message":"abcdefghijk[15]..."}` + "\n" +
+				`{"continued":true,"message":"k"}` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			e, err := New(out, tt.options...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, w := range tt.writes {
+				if tt.name == "age" && i == 2 {
+					now = now.Add(time.Second)
+				}
+				e.Write([]byte(w))
+			}
+			e.Flush()
+			e.Close()
+			if got, want := out.String(), tt.output; got != want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestLimitsBegin(t *testing.T) {
+	out := &bytes.Buffer{}
+	e, _ := New(out, MaxLines(1), JSONOutput("message", nil))
+	defer e.Close()
+	e.Write([]byte("a\n"))
+	e.Begin(KindLog, nil)
+	e.Write([]byte("b"))
+	e.Flush()
+	want := `{"flush_reason":"lines","message":"a"}` + "\n" + `{"message":"b"}` + "\n"
+	if got := out.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestLimitsProgress(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []Option
+		input   string
+		output  string
+	}{
+		{"no room", []Option{MaxLen(68), MaxSize(50), MaxLines(3), JSONOutput("message", nil)}, strings.Repeat("\x00", 56),
+			`{"flush_reason":"size","message":"\u0000\u0000\u0000\u0000[46]..."}` + "\n" +
+				`{"continued":true,"message":"\u0000\u0000[4]..."}` + "\n"},
+		{"escaped", []Option{MaxLen(60), MaxSize(10), JSONOutput("message", nil)}, strings.Repeat("\x1b", 20) + " boom",
+			`{"flush_reason":"size","message":"\u001b\u001b[8]..."}` + "\n" +
+				`{"continued":true,"message":" boom"}` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			e, err := New(out, tt.options...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			done := make(chan struct{})
+			go func() {
+				e.Write([]byte(tt.input))
+				e.Flush()
+				e.Close()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("write did not return")
+			}
+			if got, want := out.String(), tt.output; got != want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package event
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"unicode/utf8"
+)
+
+// appendLogfmtKey appends k to b, replacing chars not allowed in a logfmt key
+// by an underscore.
+func appendLogfmtKey(b []byte, k string) []byte {
+	if k == "" {
+		return append(b, '_')
+	}
+	for _, r := range k {
+		if r <= ' ' || r == '=' || r == '"' || r == utf8.RuneError {
+			r = '_'
+		}
+		b = append(b, string(r)...)
+	}
+	return b
+}
+
+// appendLogfmtValue appends v to b, quoting it if it is empty or contains
+// spaces, quotes, equal signs or control chars.
+func appendLogfmtValue(b []byte, v string) []byte {
+	if !needsLogfmtQuoting(v) {
+		return append(b, v...)
+	}
+	return strconv.AppendQuote(b, v)
+}
+
+func needsLogfmtQuoting(v string) bool {
+	if v == "" {
+		return true
+	}
+	for _, r := range v {
+		if r <= ' ' || r == '=' || r == '"' || r == '\\' || r == utf8.RuneError || !strconv.IsPrint(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// appendLogfmtAttr appends the attr of JSON encoded value to fields as logfmt
+// fields: the members of objects as dotted keys, strings and arrays quoted if
+// needed and other values as is.
+func appendLogfmtAttr(fields []field, key string, value json.RawMessage) []field {
+	value = bytes.TrimSpace(value)
+	if len(value) == 0 {
+		return fields
+	}
+	switch value[0] {
+	case '{':
+		members, err := parseObject(value)
+		if err != nil {
+			break
+		}
+		for _, m := range members {
+			fields = appendLogfmtAttr(fields, key+"."+m.key, m.value)
+		}
+		return fields
+	case '"':
+		var s string
+		if json.Unmarshal(value, &s) == nil {
+			return append(fields, field{key, string(appendLogfmtValue(nil, s))})
+		}
+	}
+	if value[0] == '[' || value[0] == '{' {
+		return append(fields, field{key, string(appendLogfmtValue(nil, string(value)))})
+	}
+	return append(fields, field{key, string(value)})
+}
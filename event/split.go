@@ -0,0 +1,101 @@
+package event
+
+import (
+	"bytes"
+	"crypto/rand"
+	"strconv"
+	"time"
+)
+
+// EventIDFunc is called to generate the id shared by the chunks of a split
+// event.
+var EventIDFunc = func() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	id := make([]byte, 0, 16)
+	for _, c := range b {
+		id = append(id, hex[c>>4], hex[c&0xf])
+	}
+	return string(id)
+}
+
+// splitMessage splits the raw message in chunks which fit in max len once
+// escaped with their chunk header. Splits fall on line boundaries when
+// possible and never inside a UTF-8 char or an escape sequence. It returns
+// no chunks if the message fits as is or if it can't be split.
+func (e *Event) splitMessage(raw []byte) (id string, chunks [][]byte) {
+	max := e.maxLen - e.overhead()
+	if len(e.escape(raw)) <= max {
+		return "", nil
+	}
+	id = EventIDFunc()
+	for total := 2; ; {
+		// The header of the last chunk is the longest as the index can't have
+		// more digits than the total
+		limit := max - len(e.chunkHeader(id, total, total))
+		if limit < len(`\u0000`) {
+			return "", nil
+		}
+		chunks = e.splitRaw(raw, limit)
+		if len(strconv.Itoa(len(chunks))) <= len(strconv.Itoa(total)) {
+			return id, chunks
+		}
+		total = len(chunks)
+	}
+}
+
+// splitRaw cuts raw in chunks of at most limit bytes once escaped. The limit
+// must fit the longest escaped char.
+func (e *Event) splitRaw(raw []byte, limit int) (chunks [][]byte) {
+	for len(raw) > 0 {
+		n := e.headLen(raw, limit)
+		if n < len(raw) {
+			if i := bytes.LastIndexByte(raw[:n], '\n'); i != -1 {
+				n = i + 1
+			}
+		}
+		chunks = append(chunks, raw[:n])
+		raw = raw[n:]
+	}
+	return
+}
+
+// chunkFields returns the event_id, chunk and chunks fields of the chunk at
+// index out of total.
+func (e *Event) chunkFields(id string, index, total int) []field {
+	value := strconv.Quote(id)
+	if e.logfmt {
+		value = string(appendLogfmtValue(nil, id))
+	}
+	return []field{
+		{"event_id", value},
+		{"chunk", strconv.Itoa(index)},
+		{"chunks", strconv.Itoa(total)},
+	}
+}
+
+// chunkHeader returns the chunk fields as added to the output. Without the
+// JSONOutput or Logfmt option, chunks start with a [id index/total] header.
+func (e *Event) chunkHeader(id string, index, total int) []byte {
+	if e.logfmt || len(e.prefix) > 0 {
+		return e.appendFields(nil, e.chunkFields(id, index, total))
+	}
+	return []byte("[" + id + " " + strconv.Itoa(index) + "/" + strconv.Itoa(total) + "] ")
+}
+
+// writeChunks writes each chunk as a record linked to the others by its
+// chunk header. Chunk indexes start at 1.
+func (e *Event) writeChunks(id string, chunks [][]byte) {
+	extra := len(e.extra)
+	for i, chunk := range chunks {
+		msg := e.escape(chunk)
+		if e.logfmt || len(e.prefix) > 0 {
+			e.extra = append(e.extra[:extra], e.chunkFields(id, i+1, len(chunks))...)
+		} else {
+			msg = append(e.chunkHeader(id, i+1, len(chunks)), msg...)
+		}
+		e.writeRecord(msg)
+	}
+}
@@ -0,0 +1,244 @@
+package event
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Strategy defines which part of a message is kept when truncated to fit in
+// max len.
+type Strategy string
+
+// Truncation strategies.
+const (
+	// KeepHead keeps the beginning of the message followed by a [N]...
+	// marker.
+	KeepHead Strategy = "head"
+	// KeepTail keeps the end of the message preceded by a [N]... marker.
+	KeepTail Strategy = "tail"
+	// KeepHeadAndTail keeps the beginning and the end of the message with a
+	// [N bytes elided] marker in the middle.
+	KeepHeadAndTail Strategy = "head-tail"
+	// KeepPanic drops the goroutines following the panicking goroutine, last
+	// first, replacing them by a [N bytes elided] marker. If the message
+	// still doesn't fit, the beginning is kept.
+	KeepPanic Strategy = "panic"
+	// Split splits the message into several records linked by their chunk
+	// fields (see SplitMessage). If the message can't be split, the
+	// beginning is kept.
+	Split Strategy = "split"
+)
+
+var goroutineSep = []byte("\n\ngoroutine ")
+
+// Truncation sets the strategy used to truncate messages larger than max len.
+// With KeepTail, KeepHeadAndTail and KeepPanic, only the beginning and the
+// end of the message are buffered, about twice max len each. With Split, the
+// whole message is buffered until flushed. Only KeepHead is supported with
+// Template.
+func Truncation(strategy Strategy) Option {
+	return func(e *Event) error {
+		switch strategy {
+		case "":
+			strategy = KeepHead
+		case KeepHead, KeepTail, KeepHeadAndTail, KeepPanic, Split:
+		default:
+			return fmt.Errorf("invalid truncation strategy: %s", strategy)
+		}
+		e.strategy = strategy
+		return nil
+	}
+}
+
+// rawBuffer returns true if the message is buffered without escaping.
+func (e *Event) rawBuffer() bool {
+	return e.tmpl != nil || (e.maxLen > 0 && e.strategy != KeepHead)
+}
+
+// bounded returns true if only the beginning and the end of the message are
+// buffered.
+func (e *Event) bounded() bool {
+	return e.maxLen > 0 && (e.strategy == KeepTail || e.strategy == KeepHeadAndTail || e.strategy == KeepPanic)
+}
+
+// writeBounded buffers the first max len bytes of the message (plus the
+// length of a goroutine separator for KeepPanic) in buf and the last max len
+// to twice max len bytes in tail. The bytes in between are only counted.
+func (e *Event) writeBounded(p []byte) {
+	if len(e.tail) == 0 {
+		n := e.maxLen + len(goroutineSep) - e.buf.Len()
+		if n >= len(p) {
+			e.buf.Write(p)
+			return
+		}
+		if n > 0 {
+			for n > 0 && !utf8.RuneStart(p[n]) {
+				n--
+			}
+			e.buf.Write(p[:n])
+			p = p[n:]
+		}
+	}
+	e.tail = append(e.tail, p...)
+	if len(e.tail) < 2*e.maxLen {
+		return
+	}
+	n := len(e.tail) - e.maxLen
+	for i := 0; i < utf8.UTFMax && n > 0 && !utf8.RuneStart(e.tail[n]); i++ {
+		n--
+	}
+	e.skipped += n
+	e.skippedRunes += utf8.RuneCount(e.tail[:n])
+	e.tail = e.tail[:copy(e.tail, e.tail[n:])]
+}
+
+// shrinkMessage escapes the raw message and shrinks it with the truncation
+// strategy to fit in max len. With a bounded buffer, raw is the beginning of
+// the message and tail its end.
+func (e *Event) shrinkMessage(raw []byte) []byte {
+	max := e.maxLen - e.overhead()
+	if len(e.tail) == 0 {
+		if msg := e.escape(raw); len(msg) <= max {
+			return msg
+		}
+	}
+	original := e.length(raw) + e.length(e.tail) + e.skipped
+	if e.countRunes {
+		original += e.skippedRunes - e.skipped
+	}
+	if e.truncFields && (e.logfmt || len(e.prefix) > 0) {
+		fields := []field{
+			{"truncated", "true"},
+			{"original_length", strconv.Itoa(original)},
+		}
+		if t, ok := e.shrink(raw, original, max-len(e.appendFields(nil, fields))); ok {
+			e.extra = append(e.extra, fields...)
+			return t
+		}
+	}
+	if t, ok := e.shrink(raw, original, max); ok {
+		return t
+	}
+	return nil
+}
+
+// shrink returns the escaped raw message shrunk to fit in max using the
+// truncation strategy. The length of the markers is computed as if the whole
+// original message was truncated so they always fit.
+func (e *Event) shrink(raw []byte, original, max int) ([]byte, bool) {
+	switch e.strategy {
+	case KeepTail:
+		max -= markerLen(original)
+		if max < 0 {
+			return nil, false
+		}
+		end := raw
+		if len(e.tail) > 0 {
+			end = e.tail
+		}
+		end = end[len(end)-e.tailLen(end, max):]
+		msg := e.appendMarker(nil, original-e.length(end))
+		return append(msg, e.escape(end)...), true
+	case KeepHeadAndTail:
+		max -= e.elidedLen(original)
+		if max < 0 {
+			return nil, false
+		}
+		head := e.headLen(raw, max/2)
+		msg := e.escape(raw[:head])
+		end := raw[head:]
+		if len(e.tail) > 0 {
+			end = e.tail
+		}
+		end = end[len(end)-e.tailLen(end, max-len(msg)):]
+		msg = e.appendElided(msg, original-e.length(raw[:head])-e.length(end))
+		return append(msg, e.escape(end)...), true
+	case KeepPanic:
+		// Cut before the last goroutine starting in the longest beginning
+		// which fits
+		first := bytes.Index(raw, goroutineSep)
+		if first == -1 {
+			break
+		}
+		limit := max - len(`\n\n`) - e.elidedLen(original)
+		if limit < 0 {
+			break
+		}
+		end := e.headLen(raw, limit) + len(goroutineSep)
+		if end > len(raw) {
+			end = len(raw)
+		}
+		if i := bytes.LastIndex(raw[:end], goroutineSep); i > first {
+			msg := append(e.escape(raw[:i]), `\n\n`...)
+			return e.appendElided(msg, original-e.length(raw[:i])), true
+		}
+	}
+	max -= markerLen(original)
+	if max < 0 {
+		return nil, false
+	}
+	head := e.headLen(raw, max)
+	return e.appendMarker(e.escape(raw[:head]), original-e.length(raw[:head])), true
+}
+
+// length returns the length of raw in bytes or runes with CountRunes.
+func (e *Event) length(raw []byte) int {
+	if e.countRunes {
+		return utf8.RuneCount(raw)
+	}
+	return len(raw)
+}
+
+// headLen returns the length of the longest beginning of raw which fits in
+// max once escaped.
+func (e *Event) headLen(raw []byte, max int) int {
+	n, l := 0, 0
+	for n < len(raw) {
+		var size int
+		e.wbuf, size = e.appendEscaped(e.wbuf[:0], raw[n:], true)
+		if l += len(e.wbuf); l > max {
+			break
+		}
+		n += size
+	}
+	return n
+}
+
+// tailLen returns the length of the longest end of raw which fits in max
+// once escaped.
+func (e *Event) tailLen(raw []byte, max int) int {
+	n, l := 0, 0
+	for n < len(raw) {
+		_, size := utf8.DecodeLastRune(raw[:len(raw)-n])
+		e.wbuf, _ = e.appendEscaped(e.wbuf[:0], raw[len(raw)-n-size:len(raw)-n], true)
+		if l += len(e.wbuf); l > max {
+			break
+		}
+		n += size
+	}
+	return n
+}
+
+// appendMarker appends the [N]... truncation marker to msg.
+func (e *Event) appendMarker(msg []byte, n int) []byte {
+	msg = append(msg, '[')
+	msg = strconv.AppendInt(msg, int64(n), 10)
+	return append(msg, "]..."...)
+}
+
+// appendElided appends the [N bytes elided] marker to msg.
+func (e *Event) appendElided(msg []byte, n int) []byte {
+	msg = append(msg, '[')
+	msg = strconv.AppendInt(msg, int64(n), 10)
+	if e.countRunes {
+		return append(msg, " runes elided]"...)
+	}
+	return append(msg, " bytes elided]"...)
+}
+
+// elidedLen returns the length of the elided marker for n elided bytes.
+func (e *Event) elidedLen(n int) int {
+	return len(e.appendElided(nil, n))
+}
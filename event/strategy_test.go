@@ -0,0 +1,166 @@
+package event
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestTruncationStrategies(t *testing.T) {
+	const alpha = "abcdefghijklmnopqrstuvwxyz0123456789"
+	const trace = "panic: boom\n\ngoroutine 1 [running]:\nmain.main()\n\n" +
+		"goroutine 2 [sleep]:\nmain.a()\n\ngoroutine 3 [sleep]:\nmain.b()"
+	tests := []struct {
+		strategy Strategy
+		maxLen   int
+		input    string
+		output   string
+	}{
+		{KeepHead, 30, alpha, "abcdefghijklmnopqrstuv[14]...\n"},
+		{KeepTail, 30, alpha, "[14]...opqrstuvwxyz0123456789\n"},
+		{KeepTail, 30, "short", "short\n"},
+		{KeepHeadAndTail, 30, alpha, "abcdef[24 bytes elided]456789\n"},
+		{KeepHeadAndTail, 30, "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk", "a\\nb\\n[13 bytes elided]\\nj\\nk\n"},
+		{KeepPanic, 80, trace, `panic: boom\n\ngoroutine 1 [running]:\nmain.main()\n\n[62 bytes elided]` + "\n"},
+		{KeepPanic, 110, trace, `panic: boom\n\ngoroutine 1 [running]:\nmain.main()\n\ngoroutine 2 [sleep]:\nmain.a()\n\n[31 bytes elided]` + "\n"},
+		{KeepPanic, 30, trace, `panic: boom\n\ngorout[90]...` + "\n"},
+		{KeepPanic, 30, alpha, "abcdefghijklmnopqrstuv[14]...\n"},
+	}
+	for _, tt := range tests {
+		out := &bytes.Buffer{}
+		e, err := New(out, MaxLen(tt.maxLen), Truncation(tt.strategy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.Write([]byte(tt.input))
+		e.Flush()
+		e.Close()
+		if got := out.String(); got != tt.output {
+			t.Errorf("%s %q: got %q, want %q", tt.strategy, tt.input, got, tt.output)
+		}
+		if out.Len() > tt.maxLen {
+			t.Errorf("%s %q: got len %d > %d", tt.strategy, tt.input, out.Len(), tt.maxLen)
+		}
+	}
+}
+
+func TestTruncationInvalid(t *testing.T) {
+	if _, err := New(&bytes.Buffer{}, Truncation("foo")); err == nil {
+		t.Error("expected error for invalid strategy")
+	}
+	if _, err := New(&bytes.Buffer{}, Template("{{.Message}}", nil), Truncation(KeepTail)); err == nil {
+		t.Error("expected error for strategy with template")
+	}
+}
+
+func TestSplit(t *testing.T) {
+	defer func(f func() string) { EventIDFunc = f }(EventIDFunc)
+	EventIDFunc = func() string { return "id" }
+	tests := []struct {
+		name    string
+		options []Option
+		input   string
+		output  string
+	}{
+		{"fits", []Option{MaxLen(20)}, "short", "short\n"},
+		{"lines", []Option{MaxLen(20)}, "line one\nline two\nend",
+			"[id 1/3] line one\\n\n" +
+				"[id 2/3] line two\\n\n" +
+				"[id 3/3] end\n"},
+		{"long line", []Option{MaxLen(20)}, "abcdefghijklmnopqrstuvwxyz",
+			"[id 1/3] abcdefghij\n" +
+				"[id 2/3] klmnopqrst\n" +
+				"[id 3/3] uvwxyz\n"},
+		{"escapes", []Option{MaxLen(18)}, "a\"b\"c\"d\"\x01\x01",
+			"[id 1/4] a\\\"b\\\"c\n" +
+				"[id 2/4] \\\"d\\\"\n" +
+				"[id 3/4] \\u0001\n" +
+				"[id 4/4] \\u0001\n"},
+		{"json", []Option{MaxLen(70), JSONOutput("message", nil)}, "line 1\nline 2\nline 3\nline 4\nline 5\nline 6\nline 7\nline 8",
+			`{"event_id":"id","chunk":1,"chunks":4,"message":"line 1\nline 2\n"}` + "\n" +
+				`{"event_id":"id","chunk":2,"chunks":4,"message":"line 3\nline 4\n"}` + "\n" +
+				`{"event_id":"id","chunk":3,"chunks":4,"message":"line 5\nline 6\n"}` + "\n" +
+				`{"event_id":"id","chunk":4,"chunks":4,"message":"line 7\nline 8"}` + "\n"},
+		{"logfmt", []Option{MaxLen(52), Logfmt("msg", nil)}, "line 1\nline 2\nline 3\nline 4\nline 5\nline 6",
+			`event_id=id chunk=1 chunks=3 msg="line 1\nline 2\n"` + "\n" +
+				`event_id=id chunk=2 chunks=3 msg="line 3\nline 4\n"` + "\n" +
+				`event_id=id chunk=3 chunks=3 msg="line 5\nline 6"` + "\n"},
+		{"too small", []Option{MaxLen(12)}, "abcdefghijklmnopqrstuvwxyz", "abcd[22]...\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			e, err := New(out, append(tt.options, Truncation(Split))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e.Write([]byte(tt.input))
+			e.Flush()
+			e.Close()
+			if got, want := out.String(), tt.output; got != want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
+			}
+			for _, line := range bytes.SplitAfter(out.Bytes(), []byte("\n")) {
+				if len(line) > e.maxLen {
+					t.Errorf("got record len %d > %d: %s", len(line), e.maxLen, line)
+				}
+			}
+		})
+	}
+}
+
+func TestTruncationBounded(t *testing.T) {
+	var dump strings.Builder
+	dump.WriteString("panic: boom é\n\ngoroutine 1 [running]:\nmain.main()\n\t/app/main.go:10 +0x1d")
+	for i := 2; i < 2000; i++ {
+		fmt.Fprintf(&dump, "\n\ngoroutine %d [sleep]:\nmain.worker(0x%x, \"é\")\n\t/app/worker.go:%d +0x2f", i, i*7919, i)
+	}
+	const head = `panic: boom é\n\ngoroutine 1 [running]:\nmain.main()\n\t/app/main.go:10 +0x1d\n\n`
+	const tail = `\n\ngoroutine 1998 [sleep]:\nmain.worker(0xf16d52, \"é\")\n\t/app/worker.go:1998 +0x2f` +
+		`\n\ngoroutine 1999 [sleep]:\nmain.worker(0xf18c41, \"é\")\n\t/app/worker.go:1999 +0x2f`
+	const goroutine2 = `goroutine 2 [sleep]:\nmain.worker(0x3dde, \"é\")\n\t/app/worker.go:2 +0x2f\n\n`
+	tests := []struct {
+		strategy Strategy
+		runes    bool
+		maxLen   int
+		output   string
+	}{
+		{KeepTail, false, 200, `[157407]...` + tail},
+		{KeepTail, true, 200, `[155410]...` + tail},
+		{KeepHeadAndTail, false, 200, head + `[157415 bytes elided]` + tail[len(tail)-82:]},
+		{KeepHeadAndTail, true, 200, head + `[155418 runes elided]` + tail[len(tail)-82:]},
+		{KeepPanic, false, 200, head + goroutine2 + `[157422 bytes elided]`},
+		{KeepPanic, true, 200, head + goroutine2 + `[155425 runes elided]`},
+		{KeepPanic, false, 20, ``},
+	}
+	for _, tt := range tests {
+		out := &bytes.Buffer{}
+		e, err := New(out, MaxLen(tt.maxLen), Truncation(tt.strategy), CountRunes(tt.runes), JSONOutput("message", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Write in small chunks and check the buffered size
+		input := []byte(dump.String())
+		buffered := 0
+		for len(input) > 0 {
+			n := 7
+			if n > len(input) {
+				n = len(input)
+			}
+			e.Write(input[:n])
+			input = input[n:]
+			if l := e.buf.Cap() + cap(e.tail); l > buffered {
+				buffered = l
+			}
+		}
+		e.Flush()
+		e.Close()
+		if got, want := out.String(), `{"message":"`+tt.output+`"}`+"\n"; got != want {
+			t.Errorf("%s runes=%v %d: got %q, want %q", tt.strategy, tt.runes, tt.maxLen, got, want)
+		}
+		if buffered > 8*tt.maxLen+4096 {
+			t.Errorf("%s runes=%v %d: buffered %d bytes", tt.strategy, tt.runes, tt.maxLen, buffered)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package event
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rs/golp/internal/diag"
+)
+
+// Kinds of events reported to Begin.
+const (
+	KindText  = "text"
+	KindLog   = "log"
+	KindPanic = "panic"
+	KindTrace = "trace"
+)
+
+// TemplateData is the data available to the Template option template for each
+// event.
+type TemplateData struct {
+	// Message is the message escaped on a single line.
+	Message string
+	// Raw is the message as read, with its new lines.
+	Raw string
+	// Kind is the kind of event (text, log, panic or trace).
+	Kind string
+	// Time is the time the event is flushed.
+	Time time.Time
+	// Context is the context given to the Template option.
+	Context map[string]string
+	// Fields are the fields extracted from the event header like the time of
+	// a log line or the value of a panic.
+	Fields map[string]string
+	// Truncated is the number of bytes (or runes with CountRunes) removed
+	// from the message to fit in max len.
+	Truncated int
+	// OriginalLength is the length of the message before truncation when
+	// truncated.
+	OriginalLength int
+	// Continued is true if the event is the follow-up of an event flushed
+	// because it reached a limit.
+	Continued bool
+	// FlushReason is the limit reached by the event if flushed because of it
+	// (size, lines or age).
+	FlushReason string
+}
+
+// TemplateFuncs are the functions available to Template option templates.
+var TemplateFuncs = template.FuncMap{
+	// json returns v encoded as JSON, strings are thus quoted and escaped.
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	// logfmt returns s quoted as a logfmt value if needed.
+	"logfmt": func(s string) string {
+		return string(appendLogfmtValue(nil, s))
+	},
+}
+
+// Template makes the event output formatted by the text/template text
+// executed with a TemplateData. A new line is added if the template output
+// does not end with one. When used with MaxLen, the message is truncated until
+// the template output fits in max len.
+func Template(text string, context map[string]string) Option {
+	return func(e *Event) error {
+		tmpl, err := template.New("format").Funcs(TemplateFuncs).Parse(text)
+		if err != nil {
+			return err
+		}
+		e.tmpl = tmpl
+		e.context = e.dynamicContext(context)
+		return nil
+	}
+}
+
+// Begin marks the start of a new event of the given kind with the fields
+// extracted from its header. This information is reset on flush. A new event
+// is never reported as continued.
+func (e *Event) Begin(kind string, fields map[string]string) {
+	done := make(chan struct{})
+	e.write <- (func() {
+		e.continued = false
+		e.kind = kind
+		e.fields = fields
+		close(done)
+	})
+	<-done
+}
+
+// doWriteRaw appends p to the buffer without escaping, as needed by templates
+// and truncation strategies other than KeepHead.
+func (e *Event) doWriteRaw(p []byte) (n int, err error) {
+	if e.exceeded > 0 {
+		e.addExceeded(p)
+		return
+	}
+	if e.bounded() {
+		e.writeBounded(p)
+		return len(p), nil
+	}
+	if e.maxLen > 0 && e.strategy == KeepHead && e.buf.Len()+len(p) > e.maxLen {
+		n = e.maxLen - e.buf.Len()
+		for n > 0 && !utf8.RuneStart(p[n]) {
+			n--
+		}
+		e.addExceeded(p[n:])
+		p = p[:n]
+	}
+	return e.buf.Write(p)
+}
+
+// doFlushTemplate writes the event using the template.
+func (e *Event) doFlushTemplate() {
+	kind := e.kind
+	if kind == "" {
+		kind = KindText
+	}
+	data := TemplateData{
+		Kind:    kind,
+		Time:    TimestampFunc(),
+		Context: e.templateContext(e.seq),
+		Fields:  e.fields,
+
+		Continued:   e.continued,
+		FlushReason: e.flushReason,
+	}
+	msg := e.buf.Bytes()
+	truncated := e.truncatedLen()
+	if truncated > 0 {
+		if e.countRunes {
+			data.OriginalLength = utf8.RuneCount(msg) + truncated
+		} else {
+			data.OriginalLength = len(msg) + truncated
+		}
+	}
+	var out bytes.Buffer
+	for {
+		data.Raw = string(msg)
+		data.Truncated = truncated
+		if truncated > 0 {
+			data.Raw += "[" + strconv.Itoa(truncated) + "]..."
+		}
+		data.Message = string(e.escape([]byte(data.Raw)))
+		out.Reset()
+		if err := e.tmpl.Execute(&out, data); err != nil {
+			diag.Printf("golp: template error: %v", err)
+			out.Reset()
+			out.WriteString(data.Message)
+		}
+		if b := out.Bytes(); len(b) == 0 || b[len(b)-1] != '\n' {
+			out.WriteByte('\n')
+		}
+		excess := out.Len() - e.maxLen
+		if e.maxLen <= 0 || excess <= 0 || len(msg) == 0 {
+			break
+		}
+		// Remove enough escaped bytes to absorb the excess plus the growth
+		// of the marker and retry
+		need := excess + markerLen(truncated+excess) - markerLen(truncated)
+		pos := len(msg)
+		for removed := 0; pos > 0 && removed < need; {
+			_, size := utf8.DecodeLastRune(msg[:pos])
+			pos -= size
+			removed += len(e.escape(msg[pos : pos+size]))
+		}
+		if e.countRunes {
+			truncated += utf8.RuneCount(msg[pos:])
+		} else {
+			truncated += len(msg) - pos
+		}
+		msg = msg[:pos]
+	}
+	if _, err := e.out.Write(out.Bytes()); err != nil {
+		logWriteErr(err)
+	}
+}
@@ -0,0 +1,64 @@
+package event
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestTemplate(t *testing.T) {
+	TimestampFunc = func() time.Time {
+		return time.Time{}
+	}
+	defer func() {
+		TimestampFunc = time.Now
+	}()
+	out := &bytes.Buffer{}
+	e, err := New(out, Template(`[{{.Context.program}}] <{{.Kind}}> {{.Fields.panic}} {{.Message}} {{json .Raw}} {{logfmt .Kind}} {{.Time.Year}}`, map[string]string{"program": "app"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	e.Begin(KindPanic, map[string]string{"panic": "boom"})
+	e.Write([]byte("panic: boom\n\"quoted\""))
+	e.Flush()
+	e.Write([]byte("text"))
+	e.Flush()
+	want := "[app] <panic> boom panic: boom\\n\\\"quoted\\\" \"panic: boom\\n\\\"quoted\\\"\" panic 1\n" +
+		"[app] <text> <no value> text \"text\" text 1\n"
+	if got := out.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestTemplateMaxLen(t *testing.T) {
+	tests := []struct {
+		maxLen int
+		input  string
+		output string
+	}{
+		{20, "abcdefghijklmnopqrstuvwxyz", "<abcdefghij[16]...>\n"},
+		{100, "abcdefghijklmnopqrstuvwxyz", "<abcdefghijklmnopqrstuvwxyz>\n"},
+		{15, "abc\n\n\n\n\n\n\ndefghijklmnop", "<abc\\n[19]...>\n"},
+		{15, "ééééééééé", "<éé[14]...>\n"},
+	}
+	for _, tt := range tests {
+		out := &bytes.Buffer{}
+		e, _ := New(out, MaxLen(tt.maxLen), Template("<{{.Message}}>", nil))
+		e.Write([]byte(tt.input))
+		e.Flush()
+		e.Close()
+		if got := out.String(); got != tt.output {
+			t.Errorf("maxLen %d: got %q, want %q", tt.maxLen, got, tt.output)
+		}
+		if got := out.Len(); got > tt.maxLen {
+			t.Errorf("maxLen %d: got len %d", tt.maxLen, got)
+		}
+	}
+}
+
+func TestTemplateInvalid(t *testing.T) {
+	if _, err := New(&bytes.Buffer{}, Template("{{", nil)); err == nil {
+		t.Error("expected error for invalid template")
+	}
+}
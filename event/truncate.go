@@ -0,0 +1,143 @@
+package event
+
+import (
+	"encoding/json"
+	"strconv"
+	"unicode/utf8"
+)
+
+// field is a key/value pair added to the JSON or logfmt output at flush time.
+// The value is JSON encoded.
+type field struct {
+	key   string
+	value string
+}
+
+// CountRunes makes truncated lengths counted in runes instead of bytes.
+func CountRunes(enabled bool) Option {
+	return func(e *Event) error {
+		e.countRunes = enabled
+		return nil
+	}
+}
+
+// TruncationFields adds truncated and original_length fields to truncated
+// events. The fields are only added with the JSONOutput or Logfmt option and
+// if they fit in max len.
+func TruncationFields(enabled bool) Option {
+	return func(e *Event) error {
+		e.truncFields = enabled
+		return nil
+	}
+}
+
+// addExceeded accounts p as truncated input.
+func (e *Event) addExceeded(p []byte) {
+	e.exceeded += len(p)
+	e.exceededRunes += utf8.RuneCount(p)
+}
+
+// truncatedLen returns the number of truncated bytes, or runes with
+// CountRunes.
+func (e *Event) truncatedLen() int {
+	if e.countRunes {
+		return e.exceededRunes
+	}
+	return e.exceeded
+}
+
+// markerFits returns true if the event has a truncated message and its
+// truncation marker alone fits into the event max len.
+func (e *Event) markerFits() bool {
+	n := e.truncatedLen()
+	return n > 0 && markerLen(n) <= e.maxLen-e.overhead()
+}
+
+// truncateMessage cuts the escaped msg so it fits into the event max len with
+// a truncation marker and the truncation fields if enabled.
+func (e *Event) truncateMessage(msg []byte) []byte {
+	original := escapedLen(msg, e.countRunes) + e.truncatedLen()
+	max := e.maxLen - e.overhead()
+	if e.truncFields && (e.logfmt || len(e.prefix) > 0) {
+		fields := []field{
+			{"truncated", "true"},
+			{"original_length", strconv.Itoa(original)},
+		}
+		if t, ok := truncate(msg, original, max-len(e.appendFields(nil, fields)), e.countRunes); ok {
+			e.extra = append(e.extra, fields...)
+			return t
+		}
+	}
+	t, _ := truncate(msg, original, max, e.countRunes)
+	return t
+}
+
+// appendFields appends fields to b, formatted for the JSON or logfmt output.
+func (e *Event) appendFields(b []byte, fields []field) []byte {
+	for _, f := range fields {
+		if e.logfmt {
+			b = appendLogfmtKey(b, f.key)
+			b = append(b, '=')
+			b = append(b, f.value...)
+			b = append(b, ' ')
+			continue
+		}
+		k, _ := json.Marshal(f.key)
+		b = append(b, k...)
+		b = append(b, ':')
+		b = append(b, f.value...)
+		b = append(b, ',')
+	}
+	return b
+}
+
+// markerLen returns the length of the truncation marker for n truncated bytes.
+func markerLen(n int) int {
+	if n == 0 {
+		return 0
+	}
+	return len("[]...") + len(strconv.Itoa(n))
+}
+
+// escapedLen returns the length of the input the escaped msg was made of, in
+// bytes or in runes.
+func escapedLen(msg []byte, runes bool) (n int) {
+	for i := 0; i < len(msg); {
+		esc, raw := unitLen(msg[i:])
+		i += esc
+		if runes {
+			raw = 1
+		}
+		n += raw
+	}
+	return
+}
+
+// truncate cuts the escaped msg so it fits into max bytes once followed by a
+// [N]... marker, N being the number of bytes (or runes) removed from the
+// original input length. The message is never cut in the middle of an escape
+// sequence or a UTF-8 char. If the marker can't fit, msg is returned
+// unchanged and ok is false.
+func truncate(msg []byte, original, max int, runes bool) (t []byte, ok bool) {
+	pos, cut := -1, 0
+	for i, n := 0, 0; ; {
+		if i+markerLen(original-n) <= max {
+			pos, cut = i, original-n
+		}
+		if i >= len(msg) {
+			break
+		}
+		esc, raw := unitLen(msg[i:])
+		if runes {
+			raw = 1
+		}
+		i += esc
+		n += raw
+	}
+	if pos == -1 {
+		return msg, false
+	}
+	msg = append(msg[:pos], '[')
+	msg = strconv.AppendInt(msg, int64(cut), 10)
+	return append(msg, "]..."...), true
+}
@@ -0,0 +1,63 @@
+package event
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestTruncateCountRunes(t *testing.T) {
+	out := &bytes.Buffer{}
+	e, _ := New(out, MaxLen(12), CountRunes(true))
+	defer e.Close()
+	e.Write([]byte("été été été"))
+	e.Flush()
+	if got, want := out.String(), "été[8]...\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestTruncationFields(t *testing.T) {
+	tests := []struct {
+		maxLen  int
+		options []Option
+		output  string
+	}{
+		{
+			100, []Option{JSONOutput("message", map[string]string{"foo": "bar"})},
+			`{"truncated":true,"original_length":104,"foo":"bar","message":"abcdefghijklmnopqrstuvwxyza[77]..."}` + "\n",
+		},
+		{
+			80, []Option{JSONOutput("message", nil)},
+			`{"truncated":true,"original_length":104,"message":"abcdefghijklmnopqrs[85]..."}` + "\n",
+		},
+		{
+			60, []Option{Logfmt("msg", nil), CountRunes(true)},
+			`truncated=true original_length=104 msg="abcdefghijk[93]..."` + "\n",
+		},
+		{
+			// no room for the fields
+			30, []Option{JSONOutput("message", nil)},
+			`{"message":"abcdefgh[96]..."}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		out := &bytes.Buffer{}
+		e, _ := New(out, append(tt.options, MaxLen(tt.maxLen), TruncationFields(true))...)
+		e.Write([]byte(strings.Repeat("abcdefghijklmnopqrstuvwxyz", 4)))
+		e.Flush()
+		e.Write([]byte("short"))
+		e.Flush()
+		e.Close()
+		lines := bytes.SplitAfter(out.Bytes(), []byte("\n"))
+		if got := string(lines[0]); got != tt.output {
+			t.Errorf("got %q, want %q", got, tt.output)
+		}
+		if len(lines[0]) > tt.maxLen {
+			t.Errorf("got len %d > %d", len(lines[0]), tt.maxLen)
+		}
+		if bytes.Contains(lines[1], []byte("truncated")) {
+			t.Errorf("fields not reset after flush: %q", lines[1])
+		}
+	}
+}
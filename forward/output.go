@@ -0,0 +1,223 @@
+// Package forward implements an output sending events to a Fluentd or Fluent
+// Bit server using the forward protocol.
+//
+// Each line written to the output is converted into a record: JSON object
+// lines are used as is, other lines are wrapped in a record containing the
+// message and the context. Records are batched and sent as Forward mode
+// messages (msgpack encoded [tag, [[time, record], ...], option]).
+package forward
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/rs/golp/batch"
+	"github.com/rs/golp/parser"
+)
+
+// Output is an io.WriteCloser sending each written line as a forward protocol
+// entry. Entries are queued and sent in batches by a batch.Queue, unsent
+// entries are retried after reconnecting.
+type Output struct {
+	*batch.Queue
+	network      string
+	addr         string
+	tag          string
+	messageKey   string
+	context      map[string]string
+	ack          bool
+	timeout      time.Duration
+	batchOptions []batch.Option
+
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// Option configures an Output.
+type Option func(o *Output) error
+
+// New creates an output sending entries tagged with tag to the forward server
+// listening on addr. The network must be tcp or unix.
+func New(network, addr, tag string, options ...Option) (*Output, error) {
+	if network != "tcp" && network != "unix" {
+		return nil, fmt.Errorf("unsupported forward network: %s", network)
+	}
+	o := &Output{
+		network:    network,
+		addr:       addr,
+		tag:        tag,
+		messageKey: "message",
+		timeout:    5 * time.Second,
+	}
+	for _, option := range options {
+		if err := option(o); err != nil {
+			return nil, err
+		}
+	}
+	q, err := batch.New("forward", o.send, o.batchOptions...)
+	if err != nil {
+		return nil, err
+	}
+	o.Queue = q
+	return o, nil
+}
+
+// Record sets the key used to store the message and the context added to
+// records created from non JSON lines.
+func Record(messageKey string, context map[string]string) Option {
+	return func(o *Output) error {
+		if messageKey != "" {
+			o.messageKey = messageKey
+		}
+		o.context = context
+		return nil
+	}
+}
+
+// Ack requests the server to acknowledge each message (chunk option). Messages
+// not acknowledged are sent again after reconnecting.
+func Ack(enabled bool) Option {
+	return func(o *Output) error {
+		o.ack = enabled
+		return nil
+	}
+}
+
+// Batch sets the options of the queue batching entries.
+func Batch(options ...batch.Option) Option {
+	return func(o *Output) error {
+		o.batchOptions = append(o.batchOptions, options...)
+		return nil
+	}
+}
+
+// BatchSize sets the maximum number of entries sent in a single message. It is
+// equivalent to Batch(batch.Size(n)).
+func BatchSize(n int) Option {
+	return Batch(batch.Size(n))
+}
+
+// BufferLimit sets the maximum number of entries kept while the server is
+// unreachable. Oldest entries are dropped first. It is equivalent to
+// Batch(batch.Limit(n)).
+func BufferLimit(n int) Option {
+	return Batch(batch.Limit(n))
+}
+
+// FlushInterval sets the maximum time an entry waits for its batch to fill. It
+// is equivalent to Batch(batch.Age(d)).
+func FlushInterval(d time.Duration) Option {
+	return Batch(batch.Age(d))
+}
+
+// Timeout sets the dial, write and ack timeout.
+func Timeout(d time.Duration) Option {
+	return func(o *Output) error {
+		o.timeout = d
+		return nil
+	}
+}
+
+// record converts a line into a forward record.
+func (o *Output) record(line []byte) map[string]interface{} {
+	if parser.IsJSON(line) {
+		var r map[string]interface{}
+		d := json.NewDecoder(bytes.NewReader(line))
+		d.UseNumber()
+		if err := d.Decode(&r); err == nil {
+			return r
+		}
+	}
+	r := make(map[string]interface{}, len(o.context)+1)
+	for k, v := range o.context {
+		r[k] = v
+	}
+	r[o.messageKey] = string(line)
+	return r
+}
+
+// Close sends the queued entries and closes the connection. Entries that can
+// not be sent are dropped.
+func (o *Output) Close() error {
+	o.Queue.Close()
+	o.disconnect()
+	return nil
+}
+
+func (o *Output) send(entries []batch.Entry) error {
+	err := o.doSend(entries)
+	if err != nil {
+		o.disconnect()
+	}
+	return err
+}
+
+func (o *Output) doSend(entries []batch.Entry) error {
+	if o.conn == nil {
+		conn, err := net.DialTimeout(o.network, o.addr, o.timeout)
+		if err != nil {
+			return err
+		}
+		o.conn = conn
+		o.r = bufio.NewReader(conn)
+	}
+	var chunk string
+	if o.ack {
+		chunk = newChunkID()
+	}
+	msg := o.encodeMessage(entries, chunk)
+	if o.timeout > 0 {
+		o.conn.SetDeadline(time.Now().Add(o.timeout))
+	}
+	if _, err := o.conn.Write(msg); err != nil {
+		return err
+	}
+	if !o.ack {
+		return nil
+	}
+	resp, err := decode(o.r)
+	if err != nil {
+		return fmt.Errorf("reading ack: %v", err)
+	}
+	if m, ok := resp.(map[string]interface{}); !ok || m["ack"] != chunk {
+		return fmt.Errorf("invalid ack: %v", resp)
+	}
+	return nil
+}
+
+func (o *Output) disconnect() {
+	if o.conn != nil {
+		o.conn.Close()
+		o.conn = nil
+		o.r = nil
+	}
+}
+
+// encodeMessage encodes a Forward mode message.
+func (o *Output) encodeMessage(entries []batch.Entry, chunk string) []byte {
+	b := appendArrayHeader(nil, 3)
+	b = appendString(b, o.tag)
+	b = appendArrayHeader(b, len(entries))
+	for _, e := range entries {
+		b = appendArrayHeader(b, 2)
+		b = appendValue(b, eventTime(e.Time))
+		b = appendValue(b, o.record(e.Line))
+	}
+	option := map[string]interface{}{"size": len(entries)}
+	if chunk != "" {
+		option["chunk"] = chunk
+	}
+	return appendValue(b, option)
+}
+
+func newChunkID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.StdEncoding.EncodeToString(b)
+}
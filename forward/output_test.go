@@ -0,0 +1,120 @@
+package forward
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rs/golp/batch"
+)
+
+func TestRoundTrip(t *testing.T) {
+	now := time.Unix(1483840912, 532597000)
+	in := []interface{}{
+		nil, true, false, "foo", int64(0), int64(200), int64(70000), int64(-1), int64(-200), int64(1 << 40),
+		1.5, eventTime(now),
+		[]interface{}{"a", int64(1)},
+		map[string]interface{}{"k": "v", "n": json.Number("42")},
+	}
+	b := appendValue(nil, in)
+	got, err := decode(bufio.NewReader(bytes.NewReader(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	in[len(in)-1] = map[string]interface{}{"k": "v", "n": int64(42)}
+	if !reflect.DeepEqual(got, in) {
+		t.Errorf("got %#v, want %#v", got, in)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	o := &Output{messageKey: "message", context: map[string]string{"foo": "bar"}}
+	tests := map[string]map[string]interface{}{
+		`{"msg":"json","n":1}`: {"msg": "json", "n": json.Number("1")},
+		`text message`:         {"foo": "bar", "message": "text message"},
+		`{"invalid`:            {"foo": "bar", "message": `{"invalid`},
+	}
+	for line, want := range tests {
+		if got := o.record([]byte(line)); !reflect.DeepEqual(got, want) {
+			t.Errorf("record(%q): got %v, want %v", line, got, want)
+		}
+	}
+}
+
+// serve accepts connections on l and sends the decoded messages to c. The
+// first fail connections are closed without acknowledging.
+func serve(l net.Listener, fail int, c chan<- []interface{}) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		r := bufio.NewReader(conn)
+		for {
+			v, err := decode(r)
+			if err != nil {
+				break
+			}
+			msg := v.([]interface{})
+			if fail > 0 {
+				fail--
+				break
+			}
+			if option := msg[2].(map[string]interface{}); option["chunk"] != nil {
+				conn.Write(appendValue(nil, map[string]interface{}{"ack": option["chunk"]}))
+			}
+			c <- msg
+		}
+		conn.Close()
+	}
+}
+
+func TestOutput(t *testing.T) {
+	batch.TimestampFunc = func() time.Time { return time.Unix(1, 0) }
+	defer func() { batch.TimestampFunc = time.Now }()
+	for _, fail := range []int{0, 1} {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs := make(chan []interface{}, 10)
+		go serve(l, fail, msgs)
+		o, err := New("tcp", l.Addr().String(), "app", Ack(true), Batch(batch.Size(2), batch.Age(time.Hour)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		o.Write([]byte("line1\n{\"message\":"))
+		o.Write([]byte("\"line2\"}\nline3"))
+		var msg []interface{}
+		select {
+		case msg = <-msgs:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("fail=%d: timeout waiting for message", fail)
+		}
+		want := []interface{}{
+			"app",
+			[]interface{}{
+				[]interface{}{eventTime(time.Unix(1, 0)), map[string]interface{}{"message": "line1"}},
+				[]interface{}{eventTime(time.Unix(1, 0)), map[string]interface{}{"message": "line2"}},
+			},
+		}
+		if !reflect.DeepEqual(msg[:2], want) {
+			t.Errorf("fail=%d: got %v, want %v", fail, msg[:2], want)
+		}
+		o.Write([]byte("\n"))
+		o.Close()
+		select {
+		case msg = <-msgs:
+			if got := len(msg[1].([]interface{})); got != 1 {
+				t.Errorf("fail=%d: got %d entries on close, want 1", fail, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("fail=%d: timeout waiting for message on close", fail)
+		}
+		l.Close()
+	}
+}
@@ -1 +1,3 @@
 module github.com/rs/golp
+
+go 1.21
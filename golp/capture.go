@@ -0,0 +1,170 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package golp
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/rs/golp/internal/diag"
+)
+
+// WatcherEnv is the environment variable set in the watcher process started
+// by Capture.
+const WatcherEnv = "GOLP_WATCHER"
+
+// Capture is the capture of the standard error of the process started by
+// Golp.Capture.
+type Capture struct {
+	// stderr is a copy of the original fd 2
+	stderr *os.File
+	// lifeline is the write end of a pipe read by the watcher, closed when
+	// the process exits
+	lifeline *os.File
+	// done receives the result of the pipeline
+	done chan error
+	cmd  *exec.Cmd
+	// once guards Close and err is its result
+	once sync.Once
+	err  error
+}
+
+// Capture redirects the standard error of the process (fd 2) into a pipe and
+// groups what is written to it into events, g.In being ignored. This covers
+// writes to os.Stderr as well as what the runtime writes directly to fd 2,
+// like panics. If g.Out is nil, events are written to the original standard
+// error, where golp diagnostics like write errors are also written while
+// capturing.
+//
+// Events are processed by a goroutine, which dies with the process on fatal
+// crashes like unrecovered panics. To not lose the crash output, a copy of the
+// program is started with the WatcherEnv environment variable set, which
+// waits for the process to exit: if it exits without calling Close, the
+// watcher processes the pipe until it is closed. In the watcher process,
+// Capture does this and exits.
+//
+// The watcher is the same executable started again with the same arguments:
+// everything the program does before calling Capture, including package
+// variable initialization and init functions, runs twice. Call Capture first
+// in main and keep init functions free of side effects like creating files or
+// connecting to services.
+//
+// The watcher only processes what is left in the pipe. The output the
+// goroutine already read, buffered or waiting for the flush delay, is lost if
+// the process exits with os.Exit or log.Fatal without calling Close first.
+func (g Golp) Capture() (*Capture, error) {
+	if os.Getenv(WatcherEnv) != "" {
+		g.watch()
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := dup(2)
+	if err != nil {
+		return nil, err
+	}
+	c := &Capture{stderr: stderr, done: make(chan error, 1)}
+	r, w, err := os.Pipe()
+	if err != nil {
+		stderr.Close()
+		return nil, err
+	}
+	lr, lw, err := os.Pipe()
+	if err != nil {
+		stderr.Close()
+		r.Close()
+		w.Close()
+		return nil, err
+	}
+	c.lifeline = lw
+	c.cmd = exec.Command(exe, os.Args[1:]...)
+	c.cmd.Env = append(os.Environ(), WatcherEnv+"=1")
+	c.cmd.Stdin = r
+	c.cmd.Stdout = stderr
+	c.cmd.Stderr = stderr
+	c.cmd.ExtraFiles = []*os.File{lr}
+	err = c.cmd.Start()
+	lr.Close()
+	if err != nil {
+		stderr.Close()
+		r.Close()
+		w.Close()
+		lw.Close()
+		return nil, err
+	}
+	g.In = r
+	if g.Out == nil {
+		g.Out = stderr
+	}
+	// Diagnostics written to fd 2 would be read back as events
+	diag.SetOutput(stderr)
+	go func() {
+		err := g.Run()
+		r.Close()
+		c.done <- err
+	}()
+	// fd 2 now holds the only reference to the write end of the pipe
+	err = dup2(int(w.Fd()), 2)
+	w.Close()
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// watch runs the watcher process: it waits for the watched process to exit
+// and runs the pipeline on the standard input if it crashed, then exits.
+func (g Golp) watch() {
+	// Signals are for the watched process, the end of the input is the end of
+	// the watch
+	signal.Ignore(os.Interrupt, syscall.SIGTERM)
+	// Close writes a byte before the lifeline is closed by the exit
+	lifeline := os.NewFile(3, "lifeline")
+	if n, _ := lifeline.Read(make([]byte, 1)); n > 0 {
+		os.Exit(0)
+	}
+	g.In = os.Stdin
+	if g.Out == nil {
+		g.Out = os.Stdout
+	}
+	if err := g.Run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// Close restores the original standard error, waits for the pending events to
+// be written and stops the watcher. Processes started while capturing inherit
+// the pipe, so Close waits for them to exit. Subsequent calls return the
+// result of the first one.
+func (c *Capture) Close() error {
+	c.once.Do(func() {
+		c.err = c.close()
+	})
+	return c.err
+}
+
+func (c *Capture) close() error {
+	// Restoring fd 2 closes the write end of the pipe
+	err := dup2(int(c.stderr.Fd()), 2)
+	if rerr := <-c.done; err == nil {
+		err = rerr
+	}
+	diag.SetOutput(os.Stderr)
+	c.lifeline.Write([]byte{0})
+	c.lifeline.Close()
+	if werr := c.cmd.Wait(); err == nil {
+		err = werr
+	}
+	if cerr := c.stderr.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,111 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package golp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	if os.Getenv(WatcherEnv) != "" || os.Getenv("GOLP_TEST_CRASH") != "" {
+		// Watcher process of the capturing tests or crashing process
+		if _, err := (Golp{MessageKey: "message"}).Capture(); err != nil {
+			os.Exit(1)
+		}
+		panic("boom")
+	}
+	os.Exit(m.Run())
+}
+
+func TestCapture(t *testing.T) {
+	out := &bytes.Buffer{}
+	g := Golp{Out: out, MessageKey: "message"}
+	c, err := g.Capture()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stderr.WriteString("panic: test\n\ngoroutine 1 [running]:\n")
+	// The runtime writes crash output to fd 2 directly
+	syscall.Write(2, []byte("main.main()\n"))
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), `{"message":"panic: test\n\ngoroutine 1 [running]:\nmain.main()"}`+"\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	// Standard error is restored
+	if _, err := os.Stderr.Stat(); err != nil {
+		t.Error(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("second close: %v", err)
+	}
+}
+
+type errWriter struct{}
+
+func (errWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write error")
+}
+
+func TestCaptureWriteError(t *testing.T) {
+	// Write errors are logged to the original standard error
+	logs, err := os.CreateTemp(t.TempDir(), "stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logs.Close()
+	stderr, err := dup(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stderr.Close()
+	dup2(int(logs.Fd()), 2)
+	defer dup2(int(stderr.Fd()), 2)
+	c, err := Golp{Out: errWriter{}, MessageKey: "message"}.Capture()
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		// Each log line is an event failing to be written
+		for i := 0; i < 2000; i++ {
+			fmt.Fprintf(os.Stderr, "2006/01/02 15:04:05 line %d %s\n", i, strings.Repeat("x", 100))
+		}
+		done <- c.Close()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("capture blocked by its own write errors")
+	}
+	b, _ := os.ReadFile(logs.Name())
+	if got := strings.Count(string(b), "golp: write error: write error\n"); got < 2000 {
+		t.Errorf("got %d write errors, want at least one per event", got)
+	}
+}
+
+func TestCaptureCrash(t *testing.T) {
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), "GOLP_TEST_CRASH=1")
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err == nil {
+		t.Fatal("got no crash")
+	}
+	// The watcher wrote the panic once the process died
+	if got, want := stderr.String(), `{"message":"panic: boom\n\ngoroutine 1 [running]:\n`; !strings.HasPrefix(got, want) {
+		t.Errorf("got %q, want prefix %q", got, want)
+	}
+}
@@ -0,0 +1,82 @@
+package golp
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/rs/golp/event"
+)
+
+// RunID is a random id identifying this run of golp, used as the ${run_id}
+// context placeholder.
+var RunID = event.EventIDFunc()
+
+// ExpandContext returns a copy of context with the following placeholders
+// replaced in the values:
+//
+//	${HOSTNAME}    the host name
+//	${env:NAME}    the value of the NAME environment variable
+//	${pid}         pid, or the pid of golp if 0
+//	${run_id}      RunID
+//
+// Other placeholders like the per event event.SeqPlaceholder are kept as is.
+func ExpandContext(context map[string]string, pid int) map[string]string {
+	if context == nil {
+		return nil
+	}
+	if pid == 0 {
+		pid = os.Getpid()
+	}
+	expanded := make(map[string]string, len(context))
+	for k, v := range context {
+		expanded[k] = expand(v, pid)
+	}
+	return expanded
+}
+
+// StaticContext returns the context expanded by ExpandContext without the
+// values containing per event placeholders. It is the context outputs can
+// send once for all the events, like resource attributes or stream labels.
+func StaticContext(context map[string]string, pid int) map[string]string {
+	static := map[string]string{}
+	for k, v := range ExpandContext(context, pid) {
+		if !strings.Contains(v, event.SeqPlaceholder) {
+			static[k] = v
+		}
+	}
+	return static
+}
+
+// expand replaces the placeholders of s.
+func expand(s string, pid int) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i == -1 {
+			break
+		}
+		j := strings.IndexByte(s[i:], '}')
+		if j == -1 {
+			break
+		}
+		b.WriteString(s[:i])
+		name := s[i+2 : i+j]
+		switch {
+		case name == "HOSTNAME":
+			host, _ := os.Hostname()
+			b.WriteString(host)
+		case strings.HasPrefix(name, "env:"):
+			b.WriteString(os.Getenv(name[len("env:"):]))
+		case name == "pid":
+			b.WriteString(strconv.Itoa(pid))
+		case name == "run_id":
+			b.WriteString(RunID)
+		default:
+			b.WriteString(s[i : i+j+1])
+		}
+		s = s[i+j+1:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
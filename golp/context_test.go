@@ -0,0 +1,38 @@
+package golp
+
+import (
+	"os"
+	"testing"
+)
+
+func TestExpandContext(t *testing.T) {
+	os.Setenv("GOLP_TEST_POD", "pod-1")
+	defer os.Unsetenv("GOLP_TEST_POD")
+	host, _ := os.Hostname()
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"plain", "plain"},
+		{"${HOSTNAME}", host},
+		{"${env:GOLP_TEST_POD}/${pid}", "pod-1/42"},
+		{"${run_id}", RunID},
+		{"${seq}", "${seq}"},
+		{"cost $5 ${", "cost $5 ${"},
+		{"${env:GOLP_TEST_UNSET}", ""},
+	}
+	for _, tt := range tests {
+		got := ExpandContext(map[string]string{"k": tt.value}, 42)["k"]
+		if got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestStaticContext(t *testing.T) {
+	context := map[string]string{"pid": "${pid}", "seq": "${seq}", "id": "${run_id}-${seq}"}
+	got := StaticContext(context, 42)
+	if len(got) != 1 || got["pid"] != "42" {
+		t.Errorf("got %v, want map[pid:42]", got)
+	}
+}
@@ -0,0 +1,23 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package golp
+
+import (
+	"os"
+	"syscall"
+)
+
+// dup returns a copy of fd.
+func dup(fd int) (*os.File, error) {
+	nfd, err := syscall.Dup(fd)
+	if err != nil {
+		return nil, err
+	}
+	syscall.CloseOnExec(nfd)
+	return os.NewFile(uintptr(nfd), "/dev/stderr"), nil
+}
+
+// dup2 makes newfd a copy of oldfd, closing newfd first.
+func dup2(oldfd, newfd int) error {
+	return syscall.Dup2(oldfd, newfd)
+}
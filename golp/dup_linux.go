@@ -0,0 +1,22 @@
+package golp
+
+import (
+	"os"
+	"syscall"
+)
+
+// dup returns a copy of fd.
+func dup(fd int) (*os.File, error) {
+	nfd, err := syscall.Dup(fd)
+	if err != nil {
+		return nil, err
+	}
+	syscall.CloseOnExec(nfd)
+	return os.NewFile(uintptr(nfd), "/dev/stderr"), nil
+}
+
+// dup2 makes newfd a copy of oldfd, closing newfd first.
+func dup2(oldfd, newfd int) error {
+	// Dup2 is not available on all linux architectures
+	return syscall.Dup3(oldfd, newfd, 0)
+}
@@ -2,16 +2,33 @@ package golp
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
-	"log"
-	"os"
-	"os/signal"
+	"strings"
 	"time"
 
 	"github.com/rs/golp/event"
 	"github.com/rs/golp/parser"
 )
 
+// Continuation grouping modes.
+const (
+	// GroupHeader groups any line not starting a new event (panic, log or
+	// JSON line) into the current event.
+	GroupHeader = "header"
+	// GroupIndent groups lines into a panic or goroutine dump only if they
+	// are indented, blank or have the shape of a stack line. Other lines
+	// start a new event.
+	GroupIndent = "indent"
+)
+
+// timestampFormat is the format of the time added with AddTimestamp.
+const timestampFormat = time.RFC3339
+
 type Golp struct {
 	In           io.Reader
 	Out          io.Writer
@@ -21,64 +38,324 @@ type Golp struct {
 	Strip        bool
 	AllowJSON    bool
 	MessageKey   string
+	Logfmt       bool
+	Format       string
 	AddTimestamp bool
+	// EscapeInvalidUTF8 keeps invalid UTF-8 bytes as \u00XX escapes instead
+	// of replacing them by U+FFFD.
+	EscapeInvalidUTF8 bool
+	// EscapeLineTerminators escapes U+2028 and U+2029.
+	EscapeLineTerminators bool
+	// TruncateRunes counts truncated lengths in runes instead of bytes.
+	TruncateRunes bool
+	// TruncationFields adds truncated and original_length fields to
+	// truncated events.
+	TruncationFields bool
+	// Truncation is the truncation strategy (head, tail, head-tail, panic or split).
+	Truncation string
+	// MaxEventSize, MaxEventLines and MaxEventAge bound the memory used by an
+	// event. When reached, the event is flushed and continued as a follow-up
+	// event.
+	MaxEventSize  int
+	MaxEventLines int
+	MaxEventAge   time.Duration
+	// FlushDelay is the delay without new line after which an event is
+	// flushed. Default is 5ms.
+	FlushDelay time.Duration
+	// PanicFlushDelay enables adaptive flushing by using this delay instead
+	// of FlushDelay while inside a panic or a goroutine dump, where more stack
+	// frames are expected.
+	PanicFlushDelay time.Duration
+	// Grouping is the continuation grouping mode (GroupHeader or
+	// GroupIndent). Default is GroupHeader.
+	Grouping string
+	// Profiles are the names of the parser profiles used to group the traces
+	// of other runtimes (java, python or node).
+	Profiles []string
+	// JSONMerge is the policy merging the context into JSON input (input,
+	// context or nest). With nest, the context is added under JSONMergeKey.
+	JSONMerge    string
+	JSONMergeKey string
+	// JSONTruncateField is the field of JSON input truncated first when
+	// larger than MaxLen. Default is to truncate the longest string values.
+	JSONTruncateField string
+	// TypedContext are context values encoded as raw JSON instead of
+	// strings in JSON output. NestedContext expands dotted context keys into
+	// nested objects.
+	TypedContext  map[string]json.RawMessage
+	NestedContext bool
+	// DrainTimeout is the time given to read the remaining input once the
+	// context given to RunContext is done. Default is 5s.
+	DrainTimeout time.Duration
+	// PID is the pid of the program whose output is read, used for the
+	// ${pid} context placeholder. Default is the pid of golp.
+	PID int
 }
 
-func (g Golp) Run() {
-	r := bufio.NewReader(g.In)
-	cont := false
+// Validate returns an error if the configuration is invalid.
+func (g Golp) Validate() error {
+	if _, err := g.profiles(); err != nil {
+		return err
+	}
+	e, err := g.newEvent(io.Discard)
+	if err != nil {
+		return err
+	}
+	return e.Close()
+}
+
+// FormatEvent returns msg formatted like the events written by Run, with attrs
+// added as fields with JSON or logfmt output.
+func (g Golp) FormatEvent(msg string, attrs []event.Attr) ([]byte, error) {
+	b := &bytes.Buffer{}
+	g.AllowJSON = false
+	e, err := g.newEvent(b)
+	if err != nil {
+		return nil, err
+	}
+	defer e.Close()
+	e.SetAttrs(attrs)
+	e.Write([]byte(msg))
+	e.Flush()
+	return b.Bytes(), nil
+}
+
+// profiles returns the parser profiles selected by Profiles.
+func (g Golp) profiles() ([]parser.Profile, error) {
+	switch g.Grouping {
+	case "", GroupHeader, GroupIndent:
+	default:
+		return nil, fmt.Errorf("invalid grouping: %s", g.Grouping)
+	}
+	profiles := make([]parser.Profile, 0, len(g.Profiles))
+	for _, name := range g.Profiles {
+		p, found := parser.Profiles[name]
+		if !found {
+			return nil, fmt.Errorf("invalid profile: %s", name)
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, nil
+}
+
+// newEvent creates the event writing to out.
+func (g Golp) newEvent(out io.Writer) (*event.Event, error) {
+	context := ExpandContext(g.Context, g.PID)
 	options := []event.Option{
+		event.TypedContext(g.TypedContext, g.NestedContext),
 		event.MaxLen(g.MaxLen),
-		event.AllowJSON(g.AllowJSON, g.Context),
+		event.AllowJSON(g.AllowJSON, context),
+		event.JSONMerge(g.JSONMerge, g.JSONMergeKey),
+		event.JSONTruncateField(g.JSONTruncateField),
+		event.EscapeInvalidUTF8(g.EscapeInvalidUTF8),
+		event.EscapeLineTerminators(g.EscapeLineTerminators),
+		event.CountRunes(g.TruncateRunes),
+		event.TruncationFields(g.TruncationFields),
+		event.Truncation(event.Strategy(g.Truncation)),
+		event.MaxSize(g.MaxEventSize),
+		event.MaxLines(g.MaxEventLines),
+		event.MaxAge(g.MaxEventAge),
 	}
-	if g.MessageKey != "" {
-		options = append(options, event.JSONOutput(g.MessageKey, g.Context))
+	if g.Format != "" {
+		options = append(options, event.Template(g.Format, context))
+	} else if g.MessageKey != "" {
+		if g.Logfmt {
+			options = append(options, event.Logfmt(g.MessageKey, context))
+		} else {
+			options = append(options, event.JSONOutput(g.MessageKey, context))
+		}
 		if g.AddTimestamp {
-			options = append(options, event.AddTimestamp("time", time.RFC3339))
+			options = append(options, event.AddTimestamp("time", timestampFormat))
 		}
 	}
-	e, err := event.New(g.Out, options...)
+	return event.New(out, options...)
+}
+
+// ErrDrainTimeout is returned by RunContext when the input did not end within
+// the drain timeout after its context was done.
+var ErrDrainTimeout = errors.New("drain timeout exceeded")
+
+// readLine is a line read from the input.
+type readLine struct {
+	line     []byte
+	isPrefix bool
+	err      error
+}
+
+// Run reads the input until EOF and writes the events to the output. It is
+// RunContext with a context never done.
+func (g Golp) Run() error {
+	return g.RunContext(context.Background())
+}
+
+// RunContext reads the input until EOF and writes the events to the output.
+// Once ctx is done, the input is drained until EOF or DrainTimeout. The
+// pending event is always flushed before returning.
+//
+// A Golp holds no resources between runs: the event buffer is released when
+// RunContext returns and the input and the output are left open for the
+// caller to close, so there is no Close method.
+//
+// If the input did not end when RunContext returns, the goroutine reading it
+// stays blocked until it is closed or ends.
+func (g Golp) RunContext(ctx context.Context) error {
+	profiles, err := g.profiles()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	autoFlushDelay := 5 * time.Millisecond
+	e, err := g.newEvent(g.Out)
+	if err != nil {
+		return err
+	}
+	defer e.Close()
+	r := bufio.NewReader(g.In)
+	cont := false
+	flushDelay := g.FlushDelay
+	if flushDelay <= 0 {
+		flushDelay = 5 * time.Millisecond
+	}
+	panicFlushDelay := g.PanicFlushDelay
+	if panicFlushDelay <= 0 {
+		panicFlushDelay = flushDelay
+	}
+	// inStack is true while reading a panic or a goroutine dump
+	inStack := false
+	// trace is the profile of the trace being read if any, chained is true
+	// if the trace announced a chained trace and last if its last line was
+	// read
+	var trace *parser.Profile
+	chained, last := false, false
+	drainTimeout := g.DrainTimeout
+	if drainTimeout <= 0 {
+		drainTimeout = 5 * time.Second
+	}
+	// Read in a goroutine so a blocked read does not prevent the drain
+	// deadline from being honored
+	lines := make(chan readLine)
+	stop := make(chan struct{})
+	defer close(stop)
 	go func() {
-		// Flush before exit
-		c := make(chan os.Signal, 1)
-		signal.Notify(c, os.Interrupt, os.Kill)
-		<-c
-		e.Flush()
-		os.Exit(1)
+		send := func(l readLine) bool {
+			select {
+			case lines <- l:
+				return true
+			case <-stop:
+				return false
+			}
+		}
+		// Lines which may be JSON objects are read up to their end so they
+		// are not escaped chunk by chunk, within the memory bound of an event
+		maxJSON := g.MaxLen
+		if g.MaxEventSize > 0 {
+			maxJSON = g.MaxEventSize
+		}
+		for {
+			line, isPrefix, err := r.ReadLine()
+			line = append([]byte(nil), line...)
+			for g.AllowJSON && isPrefix && event.IsJSON(line) && (maxJSON <= 0 || len(line) < maxJSON) {
+				var more []byte
+				more, isPrefix, err = r.ReadLine()
+				line = append(line, more...)
+				if err != nil {
+					if !send(readLine{line, false, nil}) {
+						return
+					}
+					line = nil
+				}
+			}
+			if !send(readLine{line, isPrefix, err}) || err != nil {
+				return
+			}
+		}
 	}()
+	done := ctx.Done()
+	var deadline <-chan time.Time
 	for {
-		line, isPrefix, err := r.ReadLine()
+		var l readLine
+		select {
+		case l = <-lines:
+		case <-done:
+			// Keep reading until EOF or the deadline
+			done = nil
+			deadline = time.After(drainTimeout)
+			continue
+		case <-deadline:
+			e.Flush()
+			return ErrDrainTimeout
+		}
+		line, isPrefix, err := l.line, l.isPrefix, l.err
 		if err != nil {
 			e.Flush()
 			if err != io.EOF {
-				log.Fatal(err)
+				return err
 			}
-			break
+			return nil
 		}
 		// Stop the previous auto-flush if any so we don't accidently flush
 		// before reading the new line.
 		e.Stop()
+		if !cont && trace != nil {
+			isChain := trace.IsChain != nil && trace.IsChain(line)
+			if last && (len(line) == 0 || isChain) ||
+				!last && (trace.IsContinuation(line) || chained && trace.IsHeader(line)) {
+				if isChain {
+					chained, last = true, false
+				} else if len(line) > 0 {
+					chained = false
+					last = trace.IsLast != nil && trace.IsLast(line)
+				}
+				if !e.Empty() {
+					e.Write([]byte{'\n'})
+				}
+				e.Write(line)
+				e.AutoFlush(panicFlushDelay)
+				cont = isPrefix
+				continue
+			}
+			// End of trace
+			trace = nil
+			e.Flush()
+		}
 		if !cont {
+			if e.Empty() || parser.IsGoroutine(line) {
+				// A goroutine header may start a dump without panic line
+				inStack = parser.IsGoroutine(line)
+			}
 			if parser.IsPanic(line) {
+				inStack = true
 				// Flush previous event if any
 				e.Flush()
+				e.Begin(event.KindPanic, map[string]string{
+					"panic": strings.TrimPrefix(string(line), "panic: "),
+				})
 			} else if index := parser.IsLog(line, g.Prefix); index > 0 {
+				inStack = false
 				// Flush previous event if any
 				e.Flush()
+				e.Begin(event.KindLog, map[string]string{
+					"time": strings.TrimSpace(string(line[len(g.Prefix):index])),
+				})
 				if g.Strip {
 					// Strip log message header (prefix, timestamp)
 					line = line[index:]
 				}
 			} else if g.AllowJSON && parser.IsJSON(line) {
+				inStack = false
 				// Flush previous event if any
 				e.Flush()
 				e.Write(line)
 				e.Flush()
 				continue
+			} else if p := findProfile(profiles, line); p != nil {
+				trace, chained, last = p, false, false
+				inStack = true
+				// Flush previous event if any
+				e.Flush()
+				e.Begin(event.KindTrace, map[string]string{"profile": p.Name})
+			} else if g.Grouping == GroupIndent && inStack && !parser.IsStackLine(line) {
+				// The line is not part of the stack, start a new event
+				inStack = false
+				e.Flush()
 			} else if !e.Empty() {
 				// The line is a continuation, add a quoted carriage return before
 				// appending it to the current event.
@@ -87,7 +364,21 @@ func (g Golp) Run() {
 		}
 		e.Write(line)
 		// Auto-flush the event after if no new line is read for the given delay.
-		e.AutoFlush(autoFlushDelay)
+		if inStack {
+			e.AutoFlush(panicFlushDelay)
+		} else {
+			e.AutoFlush(flushDelay)
+		}
 		cont = isPrefix
 	}
 }
+
+// findProfile returns the profile of which line is a trace header or nil.
+func findProfile(profiles []parser.Profile, line []byte) *parser.Profile {
+	for i := range profiles {
+		if profiles[i].IsHeader(line) {
+			return &profiles[i]
+		}
+	}
+	return nil
+}
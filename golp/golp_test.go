@@ -2,9 +2,14 @@ package golp
 
 import (
 	"bytes"
-	"io/ioutil"
+	"context"
+	"errors"
+	"io"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/rs/golp/event"
@@ -27,19 +32,23 @@ func TestRun(t *testing.T) {
 		jsonKey      string
 		ctx          map[string]string
 		addTimestamp bool
+		logfmt       bool
+		format       string
 	}{
-		"default":        {"testdata/input.txt", "testdata/output.txt", 0, "", false, false, "", nil, false},
-		"stripped":       {"testdata/input.txt", "testdata/output_strip.txt", 0, "", true, false, "", nil, false},
-		"maxlen":         {"testdata/input.txt", "testdata/output_maxlen.txt", 15, "", true, false, "", nil, false},
-		"json_strip":     {"testdata/input.txt", "testdata/output_strip.json", 0, "", true, false, "message", nil, false},
-		"json_maxlen":    {"testdata/input.txt", "testdata/output_maxlen.json", 26, "", true, false, "message", nil, false},
-		"json_context":   {"testdata/input.txt", "testdata/output_context.json", 0, "", true, false, "message", map[string]string{"foo": "bar"}, false},
-		"json_timestamp": {"testdata/input.txt", "testdata/output_timestamp.json", 0, "", true, false, "message", map[string]string{"foo": "bar"}, true},
-		"prefix":         {"testdata/input_prefix.txt", "testdata/output_prefix.txt", 0, "prefix ", false, false, "", nil, false},
-		"prefix_strip":   {"testdata/input_prefix.txt", "testdata/output_prefix_strip.txt", 0, "prefix ", true, false, "", nil, false},
-		"mixed_strip":    {"testdata/input_mixed.txt", "testdata/output_mixed_strip.json", 0, "", true, true, "message", nil, false},
-		"mixed_nojson":   {"testdata/input_mixed.txt", "testdata/output_mixed_nojson.json", 0, "", true, false, "message", nil, false},
-		"mixed_context":  {"testdata/input_mixed.txt", "testdata/output_mixed_context.json", 0, "", true, true, "message", map[string]string{"foo": "bar"}, false},
+		"default":        {"testdata/input.txt", "testdata/output.txt", 0, "", false, false, "", nil, false, false, ""},
+		"stripped":       {"testdata/input.txt", "testdata/output_strip.txt", 0, "", true, false, "", nil, false, false, ""},
+		"maxlen":         {"testdata/input.txt", "testdata/output_maxlen.txt", 15, "", true, false, "", nil, false, false, ""},
+		"json_strip":     {"testdata/input.txt", "testdata/output_strip.json", 0, "", true, false, "message", nil, false, false, ""},
+		"json_maxlen":    {"testdata/input.txt", "testdata/output_maxlen.json", 26, "", true, false, "message", nil, false, false, ""},
+		"json_context":   {"testdata/input.txt", "testdata/output_context.json", 0, "", true, false, "message", map[string]string{"foo": "bar"}, false, false, ""},
+		"json_timestamp": {"testdata/input.txt", "testdata/output_timestamp.json", 0, "", true, false, "message", map[string]string{"foo": "bar"}, true, false, ""},
+		"prefix":         {"testdata/input_prefix.txt", "testdata/output_prefix.txt", 0, "prefix ", false, false, "", nil, false, false, ""},
+		"prefix_strip":   {"testdata/input_prefix.txt", "testdata/output_prefix_strip.txt", 0, "prefix ", true, false, "", nil, false, false, ""},
+		"mixed_strip":    {"testdata/input_mixed.txt", "testdata/output_mixed_strip.json", 0, "", true, true, "message", nil, false, false, ""},
+		"mixed_nojson":   {"testdata/input_mixed.txt", "testdata/output_mixed_nojson.json", 0, "", true, false, "message", nil, false, false, ""},
+		"mixed_context":  {"testdata/input_mixed.txt", "testdata/output_mixed_context.json", 0, "", true, true, "message", map[string]string{"foo": "bar"}, false, false, ""},
+		"logfmt":         {"testdata/input.txt", "testdata/output_logfmt.txt", 0, "", true, false, "msg", map[string]string{"foo": "bar"}, true, true, ""},
+		"format":         {"testdata/input.txt", "testdata/output_format.txt", 0, "", true, false, "", map[string]string{"program": "app"}, false, false, "[{{.Context.program}}] <{{.Kind}}> {{with .Fields.time}}{{.}} {{end}}{{.Message}}"},
 	}
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
@@ -53,7 +62,7 @@ func TestRun(t *testing.T) {
 				t.Fatal(err)
 			}
 			defer expect.Close()
-			eb, _ := ioutil.ReadAll(expect)
+			eb, _ := io.ReadAll(expect)
 			out := &bytes.Buffer{}
 			g := Golp{
 				In:           in,
@@ -65,6 +74,8 @@ func TestRun(t *testing.T) {
 				AllowJSON:    tt.allowJSON,
 				MessageKey:   tt.jsonKey,
 				AddTimestamp: tt.addTimestamp,
+				Logfmt:       tt.logfmt,
+				Format:       tt.format,
 			}
 			g.Run()
 			if got, want := out.String(), string(eb); want != got {
@@ -73,3 +84,248 @@ func TestRun(t *testing.T) {
 		})
 	}
 }
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// waitLines waits for n lines to be written, up to 5s.
+func (b *syncBuffer) waitLines(n int) bool {
+	deadline := time.Now().Add(5 * time.Second)
+	for strings.Count(b.String(), "\n") < n {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return true
+}
+
+func TestRunPanicFlushDelay(t *testing.T) {
+	tests := map[string]struct {
+		panicFlushDelay time.Duration
+		events          int
+	}{
+		"fixed":    {0, 3},
+		"adaptive": {time.Minute, 2},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			r, w := io.Pipe()
+			out := &syncBuffer{}
+			go func() {
+				defer w.Close()
+				io.WriteString(w, "log line\n")
+				// The log line is flushed after the flush delay
+				if !out.waitLines(1) {
+					t.Error("log line not flushed")
+					return
+				}
+				io.WriteString(w, "panic: boom\n\n")
+				if tt.panicFlushDelay == 0 {
+					// The panic is flushed after the flush delay as well
+					if !out.waitLines(2) {
+						t.Error("panic not flushed")
+						return
+					}
+				} else {
+					// Frames arriving after the flush delay, well within the
+					// panic flush delay
+					time.Sleep(10 * time.Millisecond)
+				}
+				io.WriteString(w, "goroutine 1 [running]:\nmain.main()\n")
+			}()
+			g := Golp{
+				In:              r,
+				Out:             out,
+				FlushDelay:      time.Millisecond,
+				PanicFlushDelay: tt.panicFlushDelay,
+			}
+			g.Run()
+			if got, want := strings.Count(out.String(), "\n"), tt.events; got != want {
+				t.Errorf("got %d events, want %d:\n%s", got, want, out)
+			}
+		})
+	}
+}
+
+func TestRunGrouping(t *testing.T) {
+	input := "panic: boom\n\ngoroutine 1 [running]:\nmain.main()\n\t/app/main.go:5 +0x20\n" +
+		"unrelated message\ncontinued message\n"
+	tests := map[string]struct {
+		grouping string
+		output   string
+	}{
+		"header": {GroupHeader, `panic: boom\n\ngoroutine 1 [running]:\nmain.main()\n\t/app/main.go:5 +0x20\nunrelated message\ncontinued message` + "\n"},
+		"indent": {GroupIndent, `panic: boom\n\ngoroutine 1 [running]:\nmain.main()\n\t/app/main.go:5 +0x20` + "\n" +
+			`unrelated message\ncontinued message` + "\n"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			g := Golp{
+				In:       strings.NewReader(input),
+				Out:      out,
+				Grouping: tt.grouping,
+			}
+			g.Run()
+			if got, want := out.String(), tt.output; got != want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestRunProfiles(t *testing.T) {
+	input := "starting\n" +
+		"Traceback (most recent call last):\n  File \"app.py\", line 1, in <module>\nValueError: x\n" +
+		"TypeError: boom\n    at main (/app/index.js:3:7)\n\nNode.js v18.12.1\n" +
+		"Exception in thread \"main\" java.lang.RuntimeException: boom\n\tat Main.main(Main.java:5)\nCaused by: java.io.IOException\n\t... 1 more\n" +
+		"done\n"
+	want := `{"message":"starting"}` + "\n" +
+		`{"message":"Traceback (most recent call last):\n  File \"app.py\", line 1, in <module>\nValueError: x"}` + "\n" +
+		`{"message":"TypeError: boom\n    at main (/app/index.js:3:7)\n\nNode.js v18.12.1"}` + "\n" +
+		`{"message":"Exception in thread \"main\" java.lang.RuntimeException: boom\n\tat Main.main(Main.java:5)\nCaused by: java.io.IOException\n\t... 1 more"}` + "\n" +
+		`{"message":"done"}` + "\n"
+	out := &bytes.Buffer{}
+	g := Golp{
+		In:         strings.NewReader(input),
+		Out:        out,
+		MessageKey: "message",
+		Profiles:   []string{"java", "python", "node"},
+	}
+	g.Run()
+	if got := out.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := map[string]struct {
+		g     Golp
+		valid bool
+	}{
+		"default":  {Golp{}, true},
+		"complete": {Golp{MessageKey: "message", Grouping: GroupIndent, Profiles: []string{"java"}, Truncation: "split"}, true},
+		"grouping": {Golp{Grouping: "foo"}, false},
+		"profile":  {Golp{Profiles: []string{"ruby"}}, false},
+		"strategy": {Golp{Truncation: "foo"}, false},
+		"format":   {Golp{Format: "{{"}, false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tt.g.Validate()
+			if got, want := err == nil, tt.valid; got != want {
+				t.Errorf("got valid %v, want %v (%v)", got, want, err)
+			}
+		})
+	}
+}
+
+func TestRunDrain(t *testing.T) {
+	tests := map[string]struct {
+		close bool
+		err   error
+	}{
+		"eof":     {true, nil},
+		"timeout": {false, ErrDrainTimeout},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			pr, pw := io.Pipe()
+			out := &bytes.Buffer{}
+			g := Golp{In: pr, Out: out, DrainTimeout: 50 * time.Millisecond}
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error)
+			go func() {
+				done <- g.RunContext(ctx)
+			}()
+			io.WriteString(pw, "line1\n")
+			cancel()
+			io.WriteString(pw, "line2\n")
+			if tt.close {
+				pw.Close()
+			}
+			if got, want := <-done, tt.err; got != want {
+				t.Errorf("invalid error: got %v, want %v", got, want)
+			}
+			if got, want := out.String(), "line1\\nline2\n"; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			pw.Close()
+		})
+	}
+}
+
+func TestRunError(t *testing.T) {
+	tests := map[string]struct {
+		g   Golp
+		err string
+	}{
+		"profile": {Golp{In: strings.NewReader(""), Out: io.Discard, Profiles: []string{"ruby"}}, "invalid profile: ruby"},
+		"format":  {Golp{In: strings.NewReader(""), Out: io.Discard, Format: "{{"}, "template: format:1: unclosed action"},
+		"read":    {Golp{In: iotest.ErrReader(errors.New("read error")), Out: io.Discard}, "read error"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tt.g.Run()
+			if err == nil {
+				t.Fatal("got no error")
+			}
+			if got, want := err.Error(), tt.err; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestRunLongJSON(t *testing.T) {
+	line := `{"a":"` + strings.Repeat("x", 5000) + `","b":1}`
+	out := &bytes.Buffer{}
+	g := Golp{
+		In:         strings.NewReader("text\n" + line + "\n"),
+		Out:        out,
+		MessageKey: "message",
+		AllowJSON:  true,
+	}
+	if err := g.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), `{"message":"text"}`+"\n"+line+"\n"; got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRunLongJSONMaxLen(t *testing.T) {
+	line := `{"a":"` + strings.Repeat("x", 5000) + `","b":1}`
+	out := &bytes.Buffer{}
+	g := Golp{
+		In:         strings.NewReader(line + "\n"),
+		Out:        out,
+		MaxLen:     100,
+		MessageKey: "message",
+		AllowJSON:  true,
+	}
+	if err := g.Run(); err != nil {
+		t.Fatal(err)
+	}
+	// The line is longer than max len and escaped as a text message
+	want := `{"message":"{\"a\":\"` + strings.Repeat("x", 67) + `[4941]..."}` + "\n"
+	if got := out.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
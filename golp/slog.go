@@ -0,0 +1,263 @@
+package golp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/golp/event"
+)
+
+// Handler is a slog.Handler writing records as golp events, with the same
+// message key, context, truncation and output. The record time is written as
+// the time field if not zero, in the format of the AddTimestamp option.
+// Attributes become JSON fields, groups nested objects, or dotted keys in
+// logfmt output. Attributes replace the context values of the same keys,
+// unless JSONMerge is context in which case they are dropped, like attributes
+// named after the message key. A record with an error attribute carrying a
+// stack trace, i.e. printing additional lines with the %+v verb like
+// github.com/pkg/errors ones, is written as a panic event with the stack
+// appended to the message.
+type Handler struct {
+	e *event.Event
+	// shared is the state of the handlers derived from the same Golp
+	shared *handlerState
+	level  slog.Leveler
+	// attrs are the attributes added with WithAttrs and groups the current
+	// groups
+	attrs  []groupAttr
+	groups []string
+}
+
+// handlerState is the state shared by derived handlers.
+type handlerState struct {
+	// mu serializes the records, closed is true once closed and err is the
+	// result of Close
+	mu     sync.Mutex
+	closed bool
+	err    error
+}
+
+// ErrHandlerClosed is returned by Handler.Handle once the handler is closed.
+var ErrHandlerClosed = errors.New("handler closed")
+
+// groupAttr is an attribute added in groups.
+type groupAttr struct {
+	groups []string
+	attr   slog.Attr
+}
+
+// Handler returns a Handler writing records at level or above to g.Out, or
+// Info and above if level is nil. Records are written as JSON with the
+// "message" message key if g has no MessageKey and Format. The Handler must
+// be closed to release its resources.
+func (g Golp) Handler(level slog.Leveler) (*Handler, error) {
+	if g.MessageKey == "" && g.Format == "" {
+		g.MessageKey = "message"
+	}
+	g.AllowJSON = false
+	// The record time is used instead, in the same format
+	g.AddTimestamp = false
+	e, err := g.newEvent(g.Out)
+	if err != nil {
+		return nil, err
+	}
+	if level == nil {
+		level = slog.LevelInfo
+	}
+	return &Handler{e: e, shared: &handlerState{}, level: level}, nil
+}
+
+// Enabled implements the slog.Handler interface.
+func (h *Handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+// WithAttrs implements the slog.Handler interface.
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = make([]groupAttr, len(h.attrs), len(h.attrs)+len(attrs))
+	copy(h2.attrs, h.attrs)
+	for _, a := range attrs {
+		h2.attrs = append(h2.attrs, groupAttr{h.groups, a})
+	}
+	return &h2
+}
+
+// WithGroup implements the slog.Handler interface.
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.groups = append(h.groups[:len(h.groups):len(h.groups)], name)
+	return &h2
+}
+
+// Handle implements the slog.Handler interface.
+func (h *Handler) Handle(_ context.Context, r slog.Record) error {
+	o := &object{}
+	if !r.Time.IsZero() {
+		o.add(nil, slog.String(slog.TimeKey, r.Time.Format(timestampFormat)))
+	}
+	o.add(nil, slog.String(slog.LevelKey, r.Level.String()))
+	for _, ga := range h.attrs {
+		o.add(ga.groups, ga.attr)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		o.add(h.groups, a)
+		return true
+	})
+	kind := event.KindLog
+	fields := map[string]string{"level": r.Level.String()}
+	msg := r.Message
+	if o.stack != "" {
+		kind = event.KindPanic
+		fields["panic"] = o.err
+		msg += "\n" + o.stack
+	}
+	attrs := make([]event.Attr, 0, len(o.keys))
+	for _, k := range o.keys {
+		attrs = append(attrs, event.Attr{Key: k, Value: o.values[k].json()})
+	}
+	h.shared.mu.Lock()
+	defer h.shared.mu.Unlock()
+	if h.shared.closed {
+		return ErrHandlerClosed
+	}
+	h.e.Begin(kind, fields)
+	h.e.SetAttrs(attrs)
+	h.e.Write([]byte(msg))
+	h.e.Flush()
+	return nil
+}
+
+// Close releases the resources of the handler and of the handlers derived
+// from it. Subsequent calls return the result of the first one.
+func (h *Handler) Close() error {
+	h.shared.mu.Lock()
+	defer h.shared.mu.Unlock()
+	if !h.shared.closed {
+		h.shared.closed = true
+		h.shared.err = h.e.Close()
+	}
+	return h.shared.err
+}
+
+// object is a JSON object built from attributes, keeping their order.
+type object struct {
+	keys   []string
+	values map[string]value
+	// err and stack are the first error carrying a stack trace
+	err, stack string
+}
+
+// value is either a JSON encoded value or a nested object.
+type value struct {
+	raw json.RawMessage
+	obj *object
+}
+
+// add adds a to the object nested under groups.
+func (o *object) add(groups []string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		attrs := a.Value.Group()
+		if len(attrs) == 0 {
+			return
+		}
+		if a.Key != "" {
+			groups = append(groups[:len(groups):len(groups)], a.Key)
+		}
+		for _, ga := range attrs {
+			o.add(groups, ga)
+		}
+		return
+	}
+	target := o
+	for _, g := range groups {
+		target = target.child(g)
+	}
+	target.set(a.Key, value{raw: o.encode(a.Value)})
+}
+
+// child returns the nested object at key, creating it if needed.
+func (o *object) child(key string) *object {
+	if v, found := o.values[key]; found && v.obj != nil {
+		return v.obj
+	}
+	c := &object{}
+	o.set(key, value{obj: c})
+	return c
+}
+
+// set sets key to v, replacing any previous value.
+func (o *object) set(key string, v value) {
+	if o.values == nil {
+		o.values = map[string]value{}
+	}
+	if _, found := o.values[key]; !found {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = v
+}
+
+// encode returns v JSON encoded, recording the first error carrying a stack
+// trace.
+func (o *object) encode(v slog.Value) json.RawMessage {
+	var x interface{}
+	switch v.Kind() {
+	case slog.KindDuration:
+		x = v.Duration().String()
+	case slog.KindTime:
+		x = v.Time().Format(time.RFC3339Nano)
+	case slog.KindAny:
+		x = v.Any()
+		if err, ok := x.(error); ok {
+			msg := err.Error()
+			if o.stack == "" {
+				if s := fmt.Sprintf("%+v", err); strings.Count(s, "\n") > strings.Count(msg, "\n") {
+					// The error is already in the attribute
+					o.err, o.stack = msg, strings.TrimLeft(strings.TrimPrefix(s, msg), "\n")
+				}
+			}
+			x = msg
+		}
+	default:
+		x = v.Any()
+	}
+	b, err := json.Marshal(x)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprint(x))
+	}
+	return b
+}
+
+// json returns the value JSON encoded.
+func (v value) json() json.RawMessage {
+	if v.obj == nil {
+		return v.raw
+	}
+	b := &bytes.Buffer{}
+	b.WriteByte('{')
+	for i, k := range v.obj.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(k)
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(v.obj.values[k].json())
+	}
+	b.WriteByte('}')
+	return b.Bytes()
+}
@@ -0,0 +1,115 @@
+package golp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+// stackError is an error printing a stack trace with %+v.
+type stackError struct{}
+
+func (stackError) Error() string {
+	return "boom"
+}
+
+func (err stackError) Format(s fmt.State, verb rune) {
+	fmt.Fprint(s, err.Error())
+	if s.Flag('+') {
+		fmt.Fprint(s, "\nmain.main()\n\t/app/main.go:10")
+	}
+}
+
+// fixedTime is a handler setting the time of the records.
+type fixedTime struct {
+	slog.Handler
+	t time.Time
+}
+
+func (h fixedTime) Handle(ctx context.Context, r slog.Record) error {
+	r.Time = h.t
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h fixedTime) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return fixedTime{h.Handler.WithAttrs(attrs), h.t}
+}
+
+func (h fixedTime) WithGroup(name string) slog.Handler {
+	return fixedTime{h.Handler.WithGroup(name), h.t}
+}
+
+func TestHandler(t *testing.T) {
+	const ts = `"time":"2024-01-02T03:04:05Z",`
+	tests := []struct {
+		name   string
+		g      Golp
+		log    func(l *slog.Logger)
+		output string
+	}{
+		{"attrs", Golp{Context: map[string]string{"app": "x"}}, func(l *slog.Logger) {
+			l.Info("hello", "n", 1, "d", time.Second, "err", errors.New("failed"))
+		}, `{` + ts + `"level":"INFO","n":1,"d":"1s","err":"failed","app":"x","message":"hello"}` + "\n"},
+		{"collisions", Golp{Context: map[string]string{"app": "x", "env": "prod"}}, func(l *slog.Logger) {
+			l.Info("hello", "app", "y", "message", "m")
+		}, `{` + ts + `"level":"INFO","app":"y","env":"prod","message":"hello"}` + "\n"},
+		{"merge context", Golp{Context: map[string]string{"app": "x"}, JSONMerge: "context"}, func(l *slog.Logger) {
+			l.Info("hello", "app", "y")
+		}, `{` + ts + `"level":"INFO","app":"x","message":"hello"}` + "\n"},
+		{"groups", Golp{}, func(l *slog.Logger) {
+			l.With("a", 1).WithGroup("req").With("id", "r1").Warn("slow", slog.Group("timing", "ms", 10), "path", "/")
+		}, `{` + ts + `"level":"WARN","a":1,"req":{"id":"r1","timing":{"ms":10},"path":"/"},"message":"slow"}` + "\n"},
+		{"level", Golp{}, func(l *slog.Logger) {
+			l.Debug("hidden")
+		}, ""},
+		{"stack", Golp{}, func(l *slog.Logger) {
+			l.Error("crash", "err", stackError{})
+		}, `{` + ts + `"level":"ERROR","err":"boom","message":"crash\nmain.main()\n\t/app/main.go:10"}` + "\n"},
+		{"logfmt", Golp{MessageKey: "msg", Logfmt: true}, func(l *slog.Logger) {
+			l.WithGroup("req").Info("hello", "n", 1, "path", "/a b", slog.Group("user", "id", 2))
+		}, `time=2024-01-02T03:04:05Z level=INFO req.n=1 req.path="/a b" req.user.id=2 msg="hello"` + "\n"},
+		{"max len", Golp{MaxLen: 82}, func(l *slog.Logger) {
+			l.Info("abcdefghijklmnopqrstuvwxyz", "n", 1)
+		}, `{` + ts + `"level":"INFO","n":1,"message":"abcdefghi[17]..."}` + "\n"},
+		{"zero time", Golp{}, func(l *slog.Logger) {
+			l.Handler().(fixedTime).Handler.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "hello", 0))
+		}, `{"level":"INFO","message":"hello"}` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			tt.g.Out = out
+			h, err := tt.g.Handler(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer h.Close()
+			tt.log(slog.New(fixedTime{h, time.Date(2024, 1, 2, 3, 4, 5, 5e8, time.UTC)}))
+			if got, want := out.String(), tt.output; got != want {
+				t.Errorf("got %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestHandlerClose(t *testing.T) {
+	h, err := Golp{Out: &bytes.Buffer{}}.Handler(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := slog.New(h.WithAttrs([]slog.Attr{slog.Int("n", 1)}))
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Close(); err != nil {
+		t.Errorf("second close: %v", err)
+	}
+	err = l.Handler().Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelInfo, "hello", 0))
+	if !errors.Is(err, ErrHandlerClosed) {
+		t.Errorf("got %v, want %v", err, ErrHandlerClosed)
+	}
+}
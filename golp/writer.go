@@ -0,0 +1,53 @@
+package golp
+
+import (
+	"io"
+	"sync"
+)
+
+// Writer is an io.WriteCloser grouping what is written to it into events, to
+// be used with log.New or http.Server.ErrorLog. It is safe for concurrent
+// use, each write being processed as a whole, so writes should end with a new
+// line like log.Logger ones do.
+type Writer struct {
+	w    *io.PipeWriter
+	done chan error
+	// once guards Close and err is its result
+	once sync.Once
+	err  error
+}
+
+// Writer returns a Writer processing its input with g, g.In being ignored.
+// The Writer must be closed to flush the pending event. The output is left
+// open for the caller to close.
+func (g Golp) Writer() (*Writer, error) {
+	if err := g.Validate(); err != nil {
+		return nil, err
+	}
+	r, w := io.Pipe()
+	g.In = r
+	wr := &Writer{w: w, done: make(chan error, 1)}
+	go func() {
+		err := g.Run()
+		// Unblock writers if Run stopped before the end of the input
+		r.CloseWithError(err)
+		wr.done <- err
+	}()
+	return wr, nil
+}
+
+// Write implements the io.Writer interface.
+func (wr *Writer) Write(p []byte) (int, error) {
+	return wr.w.Write(p)
+}
+
+// Close flushes the pending event and waits for it to be written. Subsequent
+// calls return the result of the first one.
+func (wr *Writer) Close() error {
+	wr.once.Do(func() {
+		if wr.err = wr.w.Close(); wr.err == nil {
+			wr.err = <-wr.done
+		}
+	})
+	return wr.err
+}
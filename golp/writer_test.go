@@ -0,0 +1,53 @@
+package golp
+
+import (
+	"bytes"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	out := &bytes.Buffer{}
+	w, err := Golp{Out: out, MessageKey: "message"}.Writer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := log.New(w, "", 0)
+	var wg sync.WaitGroup
+	for _, msg := range []string{"a", "b", "c"} {
+		wg.Add(1)
+		go func(msg string) {
+			defer wg.Done()
+			l.Print("panic: " + msg + "\n\ngoroutine 1 [running]:")
+		}(msg)
+	}
+	wg.Wait()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Split(strings.TrimSpace(out.String()), "\n")
+	sort.Strings(got)
+	want := []string{
+		`{"message":"panic: a\n\ngoroutine 1 [running]:"}`,
+		`{"message":"panic: b\n\ngoroutine 1 [running]:"}`,
+		`{"message":"panic: c\n\ngoroutine 1 [running]:"}`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, err := w.Write([]byte("x\n")); err == nil {
+		t.Error("got no error writing to a closed writer")
+	}
+	if err := w.Close(); err != nil {
+		t.Errorf("second close: %v", err)
+	}
+}
+
+func TestWriterInvalid(t *testing.T) {
+	if _, err := (Golp{Profiles: []string{"ruby"}}).Writer(); err == nil {
+		t.Error("got no error")
+	}
+}
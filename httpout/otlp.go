@@ -0,0 +1,193 @@
+package httpout
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/golp/batch"
+	"github.com/rs/golp/parser"
+)
+
+// severities maps common level names to OpenTelemetry severity numbers.
+var severities = map[string]int{
+	"trace":   1,
+	"debug":   5,
+	"info":    9,
+	"warn":    13,
+	"warning": 13,
+	"error":   17,
+	"fatal":   21,
+	"panic":   21,
+}
+
+// otlpBody encodes entries as an OTLP/HTTP JSON logs export request. The
+// context is sent as resource attributes, the message key as the body and
+// other fields as attributes. Panics get the FATAL severity with their stack
+// stored in the exception.stacktrace attribute.
+func (o *Output) otlpBody(entries []batch.Entry) []byte {
+	resource := o.resource()
+	records := make([]interface{}, 0, len(entries))
+	for _, e := range entries {
+		records = append(records, o.otlpRecord(e, resource))
+	}
+	b, _ := json.Marshal(map[string]interface{}{
+		"resourceLogs": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": otlpAttributes(resource),
+				},
+				"scopeLogs": []interface{}{
+					map[string]interface{}{
+						"scope":      map[string]interface{}{"name": "golp"},
+						"logRecords": records,
+					},
+				},
+			},
+		},
+	})
+	return b
+}
+
+// otlpRecord converts e into an OTLP log record. The fields holding the
+// values of the resource taken from the context are left out.
+func (o *Output) otlpRecord(e batch.Entry, resource map[string]interface{}) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if parser.IsJSON(e.Line) {
+		d := json.NewDecoder(bytes.NewReader(e.Line))
+		d.UseNumber()
+		if err := d.Decode(&fields); err != nil {
+			fields = map[string]interface{}{}
+		}
+	}
+	if len(fields) == 0 {
+		fields[o.messageKey] = string(e.Line)
+	}
+	for k, v := range resource {
+		if reflect.DeepEqual(fields[k], v) {
+			delete(fields, k)
+		} else if o.nestedContext && strings.IndexByte(k, '.') > 0 {
+			// The context is nested in the events
+			deleteNested(fields, strings.Split(k, "."), v)
+		}
+	}
+	ts := e.Time
+	if s, ok := fields["time"].(string); ok {
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			ts = t
+			delete(fields, "time")
+		}
+	}
+	r := map[string]interface{}{
+		"timeUnixNano":         strconv.FormatInt(ts.UnixNano(), 10),
+		"observedTimeUnixNano": strconv.FormatInt(e.Time.UnixNano(), 10),
+	}
+	if level, ok := fields["level"].(string); ok {
+		if n, found := severities[strings.ToLower(level)]; found {
+			r["severityNumber"] = n
+			r["severityText"] = strings.ToUpper(level)
+			delete(fields, "level")
+		}
+	}
+	if msg, ok := fields[o.messageKey].(string); ok {
+		delete(fields, o.messageKey)
+		if parser.IsPanic([]byte(msg)) {
+			first := msg
+			if i := strings.IndexByte(msg, '\n'); i != -1 {
+				first = msg[:i]
+			}
+			r["severityNumber"] = 21
+			r["severityText"] = "FATAL"
+			fields["exception.type"] = "panic"
+			fields["exception.message"] = strings.TrimPrefix(first, "panic: ")
+			fields["exception.stacktrace"] = msg
+			msg = first
+		}
+		r["body"] = otlpValue(msg)
+	}
+	if len(fields) > 0 {
+		r["attributes"] = otlpAttributes(fields)
+	}
+	return r
+}
+
+// deleteNested deletes the value at path in the nested objects of m if it is
+// v, along with the objects left empty.
+func deleteNested(m map[string]interface{}, path []string, v interface{}) {
+	if len(path) == 1 {
+		if reflect.DeepEqual(m[path[0]], v) {
+			delete(m, path[0])
+		}
+		return
+	}
+	child, ok := m[path[0]].(map[string]interface{})
+	if !ok {
+		return
+	}
+	deleteNested(child, path[1:], v)
+	if len(child) == 0 {
+		delete(m, path[0])
+	}
+}
+
+// resource returns the context as resource attributes, typed context values
+// keeping their type.
+func (o *Output) resource() map[string]interface{} {
+	r := make(map[string]interface{}, len(o.context))
+	for k, v := range o.context {
+		r[k] = v
+		if raw, found := o.typedContext[k]; found {
+			var value interface{}
+			d := json.NewDecoder(bytes.NewReader(raw))
+			d.UseNumber()
+			if err := d.Decode(&value); err == nil {
+				r[k] = value
+			}
+		}
+	}
+	return r
+}
+
+// otlpAttributes converts m into a sorted list of OTLP key/values.
+func otlpAttributes(m map[string]interface{}) []interface{} {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	attrs := make([]interface{}, 0, len(keys))
+	for _, k := range keys {
+		attrs = append(attrs, map[string]interface{}{"key": k, "value": otlpValue(m[k])})
+	}
+	return attrs
+}
+
+// otlpValue converts a decoded JSON value into an OTLP AnyValue.
+func otlpValue(v interface{}) map[string]interface{} {
+	switch v := v.(type) {
+	case string:
+		return map[string]interface{}{"stringValue": v}
+	case bool:
+		return map[string]interface{}{"boolValue": v}
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return map[string]interface{}{"intValue": v.String()}
+		}
+		f, _ := v.Float64()
+		return map[string]interface{}{"doubleValue": f}
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i, e := range v {
+			values[i] = otlpValue(e)
+		}
+		return map[string]interface{}{"arrayValue": map[string]interface{}{"values": values}}
+	case map[string]interface{}:
+		return map[string]interface{}{"kvlistValue": map[string]interface{}{"values": otlpAttributes(v)}}
+	default:
+		return map[string]interface{}{}
+	}
+}
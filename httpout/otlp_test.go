@@ -0,0 +1,77 @@
+package httpout
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/rs/golp/batch"
+)
+
+func TestOTLPBody(t *testing.T) {
+	o := &Output{messageKey: "message", context: map[string]string{"service.name": "app"}}
+	observed := time.Unix(2, 0)
+	entries := []batch.Entry{
+		{Time: observed, Line: []byte(`{"service.name":"app","message":"panic: boom\n\ngoroutine 1 [running]:","time":"1970-01-01T00:00:01Z"}`)},
+		{Time: observed, Line: []byte(`{"level":"warn","message":"text","n":1,"ok":true}`)},
+		{Time: observed, Line: []byte(`raw line`)},
+	}
+	want := `{"resourceLogs":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"app"}}]},` +
+		`"scopeLogs":[{"logRecords":[` +
+		`{"attributes":[{"key":"exception.message","value":{"stringValue":"boom"}},` +
+		`{"key":"exception.stacktrace","value":{"stringValue":"panic: boom\n\ngoroutine 1 [running]:"}},` +
+		`{"key":"exception.type","value":{"stringValue":"panic"}}],` +
+		`"body":{"stringValue":"panic: boom"},"observedTimeUnixNano":"2000000000","severityNumber":21,"severityText":"FATAL","timeUnixNano":"1000000000"},` +
+		`{"attributes":[{"key":"n","value":{"intValue":"1"}},{"key":"ok","value":{"boolValue":true}}],` +
+		`"body":{"stringValue":"text"},"observedTimeUnixNano":"2000000000","severityNumber":13,"severityText":"WARN","timeUnixNano":"2000000000"},` +
+		`{"body":{"stringValue":"raw line"},"observedTimeUnixNano":"2000000000","timeUnixNano":"2000000000"}` +
+		`],"scope":{"name":"golp"}}]}]}`
+	got := o.otlpBody(entries)
+	if !json.Valid(got) {
+		t.Fatalf("invalid JSON: %s", got)
+	}
+	if string(got) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestOTLPTypedContext(t *testing.T) {
+	o := &Output{
+		messageKey:    "message",
+		context:       map[string]string{"service.name": "app", "port": "8080"},
+		typedContext:  map[string]json.RawMessage{"port": []byte("8080")},
+		nestedContext: true,
+	}
+	entries := []batch.Entry{
+		{Time: time.Unix(2, 0), Line: []byte(`{"port":8080,"service":{"name":"app"},"message":"text"}`)},
+	}
+	want := `{"resourceLogs":[{"resource":{"attributes":[{"key":"port","value":{"intValue":"8080"}},` +
+		`{"key":"service.name","value":{"stringValue":"app"}}]},` +
+		`"scopeLogs":[{"logRecords":[` +
+		`{"body":{"stringValue":"text"},"observedTimeUnixNano":"2000000000","timeUnixNano":"2000000000"}` +
+		`],"scope":{"name":"golp"}}]}]}`
+	if got := string(o.otlpBody(entries)); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestOTLPContextCollision(t *testing.T) {
+	o := &Output{
+		messageKey:    "message",
+		context:       map[string]string{"env": "prod", "service.name": "app"},
+		nestedContext: true,
+	}
+	entries := []batch.Entry{
+		// Values from the input under context keys are not the resource ones
+		{Time: time.Unix(2, 0), Line: []byte(`{"env":"dev","service":{"name":"app","version":"1"},"message":"text"}`)},
+	}
+	want := `{"resourceLogs":[{"resource":{"attributes":[{"key":"env","value":{"stringValue":"prod"}},` +
+		`{"key":"service.name","value":{"stringValue":"app"}}]},` +
+		`"scopeLogs":[{"logRecords":[` +
+		`{"attributes":[{"key":"env","value":{"stringValue":"dev"}},{"key":"service","value":{"kvlistValue":{"values":[{"key":"version","value":{"stringValue":"1"}}]}}}],` +
+		`"body":{"stringValue":"text"},"observedTimeUnixNano":"2000000000","timeUnixNano":"2000000000"}` +
+		`],"scope":{"name":"golp"}}]}]}`
+	if got := string(o.otlpBody(entries)); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
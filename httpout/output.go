@@ -0,0 +1,253 @@
+// Package httpout implements an output pushing batches of events to an HTTP
+// endpoint like a generic webhook, Elasticsearch bulk API, Loki push API or
+// OpenTelemetry collector.
+package httpout
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rs/golp/batch"
+	"github.com/rs/golp/internal/diag"
+	"github.com/rs/golp/parser"
+)
+
+// Payload formats supported by the output.
+const (
+	// JSON sends events as newline delimited JSON objects.
+	JSON = "json"
+	// Elasticsearch sends events using the Elasticsearch _bulk API format.
+	// The index must be part of the URL (i.e.: http://host:9200/index/_bulk).
+	Elasticsearch = "elasticsearch"
+	// Loki sends events using the Loki push API format
+	// (i.e.: http://host:3100/loki/api/v1/push).
+	Loki = "loki"
+	// OTLP sends events as OpenTelemetry log records using the OTLP/HTTP JSON
+	// encoding (i.e.: http://host:4318/v1/logs). The context is sent as
+	// resource attributes.
+	OTLP = "otlp"
+)
+
+// Output is an io.WriteCloser sending each written line as an event to an HTTP
+// endpoint. Events are queued and sent in batches by a batch.Queue. Batches
+// are sent again on network errors and 5xx or 429 responses.
+type Output struct {
+	*batch.Queue
+	url        string
+	format     string
+	client     *http.Client
+	header     http.Header
+	gzip       bool
+	labels     map[string]string
+	messageKey string
+	context    map[string]string
+	// typedContext are context values encoded as raw JSON and nestedContext
+	// is true if dotted context keys are nested objects in the events
+	typedContext  map[string]json.RawMessage
+	nestedContext bool
+	batchOptions  []batch.Option
+}
+
+// Option configures an Output.
+type Option func(o *Output) error
+
+// New creates an output posting batches of events to url.
+func New(url string, options ...Option) (*Output, error) {
+	o := &Output{
+		url:          url,
+		format:       JSON,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		header:       http.Header{},
+		messageKey:   "message",
+		labels:       map[string]string{"job": "golp"},
+		batchOptions: []batch.Option{batch.Retries(5)},
+	}
+	for _, option := range options {
+		if err := option(o); err != nil {
+			return nil, err
+		}
+	}
+	q, err := batch.New("http", o.send, o.batchOptions...)
+	if err != nil {
+		return nil, err
+	}
+	o.Queue = q
+	return o, nil
+}
+
+// Format sets the payload format (JSON, Elasticsearch, Loki or OTLP).
+func Format(format string) Option {
+	return func(o *Output) error {
+		switch format {
+		case JSON, Elasticsearch, Loki, OTLP:
+			o.format = format
+			return nil
+		}
+		return fmt.Errorf("unsupported http format: %s", format)
+	}
+}
+
+// Gzip enables gzip compression of request bodies.
+func Gzip(enabled bool) Option {
+	return func(o *Output) error {
+		o.gzip = enabled
+		return nil
+	}
+}
+
+// Header adds a header to each request.
+func Header(key, value string) Option {
+	return func(o *Output) error {
+		o.header.Add(key, value)
+		return nil
+	}
+}
+
+// Labels sets the stream labels used with the Loki format.
+func Labels(labels map[string]string) Option {
+	return func(o *Output) error {
+		if len(labels) == 0 {
+			return nil
+		}
+		o.labels = labels
+		return nil
+	}
+}
+
+// Record sets the key used to store the message and the context added to
+// events created from non JSON lines.
+func Record(messageKey string, context map[string]string) Option {
+	return func(o *Output) error {
+		if messageKey != "" {
+			o.messageKey = messageKey
+		}
+		o.context = context
+		return nil
+	}
+}
+
+// TypedContext sets the context values encoded as raw JSON instead of strings,
+// sent with their type as OTLP resource attributes. With nested, the dotted
+// context keys are expected as nested objects in the events.
+func TypedContext(typed map[string]json.RawMessage, nested bool) Option {
+	return func(o *Output) error {
+		o.typedContext = typed
+		o.nestedContext = nested
+		return nil
+	}
+}
+
+// Client sets the HTTP client used to send requests.
+func Client(c *http.Client) Option {
+	return func(o *Output) error {
+		o.client = c
+		return nil
+	}
+}
+
+// Batch sets the options of the queue batching events.
+func Batch(options ...batch.Option) Option {
+	return func(o *Output) error {
+		o.batchOptions = append(o.batchOptions, options...)
+		return nil
+	}
+}
+
+// record returns line as a JSON object. Non JSON lines are wrapped in an
+// object containing the context and the message.
+func (o *Output) record(line []byte) []byte {
+	if parser.IsJSON(line) && json.Valid(line) {
+		return line
+	}
+	r := make(map[string]string, len(o.context)+1)
+	for k, v := range o.context {
+		r[k] = v
+	}
+	r[o.messageKey] = string(line)
+	b, _ := json.Marshal(r)
+	return b
+}
+
+// body encodes entries using the output format.
+func (o *Output) body(entries []batch.Entry) ([]byte, string) {
+	var buf bytes.Buffer
+	switch o.format {
+	case Elasticsearch:
+		for _, e := range entries {
+			buf.WriteString("{\"index\":{}}\n")
+			buf.Write(o.record(e.Line))
+			buf.WriteByte('\n')
+		}
+		return buf.Bytes(), "application/x-ndjson"
+	case Loki:
+		values := make([][2]string, len(entries))
+		for i, e := range entries {
+			values[i] = [2]string{strconv.FormatInt(e.Time.UnixNano(), 10), string(o.record(e.Line))}
+		}
+		b, _ := json.Marshal(map[string]interface{}{
+			"streams": []interface{}{
+				map[string]interface{}{"stream": o.labels, "values": values},
+			},
+		})
+		return b, "application/json"
+	case OTLP:
+		return o.otlpBody(entries), "application/json"
+	default:
+		for _, e := range entries {
+			buf.Write(o.record(e.Line))
+			buf.WriteByte('\n')
+		}
+		return buf.Bytes(), "application/x-ndjson"
+	}
+}
+
+func (o *Output) send(entries []batch.Entry) error {
+	body, contentType := o.body(entries)
+	if o.gzip {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Write(body)
+		w.Close()
+		body = buf.Bytes()
+	}
+	req, err := http.NewRequest("POST", o.url, bytes.NewReader(body))
+	if err != nil {
+		return batch.Permanent(err)
+	}
+	for k, v := range o.header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", contentType)
+	if o.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	res, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests {
+		io.Copy(io.Discard, res.Body)
+		return fmt.Errorf("unexpected response: %s", res.Status)
+	}
+	if res.StatusCode >= 300 {
+		io.Copy(io.Discard, res.Body)
+		return batch.Permanent(fmt.Errorf("unexpected response: %s", res.Status))
+	}
+	if o.format == Elasticsearch {
+		var r struct {
+			Errors bool `json:"errors"`
+		}
+		if json.NewDecoder(res.Body).Decode(&r) == nil && r.Errors {
+			diag.Printf("golp: http error: some events were rejected by elasticsearch")
+		}
+	}
+	io.Copy(io.Discard, res.Body)
+	return nil
+}
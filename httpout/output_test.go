@@ -0,0 +1,121 @@
+package httpout
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rs/golp/batch"
+)
+
+type request struct {
+	header http.Header
+	body   string
+}
+
+// server returns a test server answering with the given status codes in
+// sequence (200 once exhausted) and reporting received requests to c.
+func server(c chan<- request, statuses ...int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			body, _ = gzip.NewReader(r.Body)
+		}
+		b, _ := io.ReadAll(body)
+		c <- request{r.Header, string(b)}
+		if len(statuses) > 0 {
+			w.WriteHeader(statuses[0])
+			statuses = statuses[1:]
+		}
+	}))
+}
+
+func TestFormats(t *testing.T) {
+	batch.TimestampFunc = func() time.Time { return time.Unix(1, 0) }
+	defer func() { batch.TimestampFunc = time.Now }()
+	tests := map[string]struct {
+		options []Option
+		want    string
+	}{
+		"json": {
+			[]Option{Record("msg", map[string]string{"foo": "bar"})},
+			"{\"foo\":\"bar\",\"msg\":\"line1\"}\n{\"message\":\"line2\"}\n",
+		},
+		"elasticsearch": {
+			[]Option{Format(Elasticsearch)},
+			"{\"index\":{}}\n{\"message\":\"line1\"}\n{\"index\":{}}\n{\"message\":\"line2\"}\n",
+		},
+		"loki": {
+			[]Option{Format(Loki), Labels(map[string]string{"app": "test"})},
+			`{"streams":[{"stream":{"app":"test"},"values":[["1000000000","{\"message\":\"line1\"}"],["1000000000","{\"message\":\"line2\"}"]]}]}`,
+		},
+		"gzip": {
+			[]Option{Gzip(true), Header("Authorization", "secret")},
+			"{\"message\":\"line1\"}\n{\"message\":\"line2\"}\n",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := make(chan request, 10)
+			s := server(c)
+			defer s.Close()
+			o, err := New(s.URL, append(tt.options, Batch(batch.Size(2)))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer o.Close()
+			o.Write([]byte("line1\n{\"message\":\"line2\"}\n"))
+			select {
+			case r := <-c:
+				if r.body != tt.want {
+					t.Errorf("got %s, want %s", r.body, tt.want)
+				}
+				if name == "gzip" && r.header.Get("Authorization") != "secret" {
+					t.Errorf("missing custom header")
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("timeout waiting for request")
+			}
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	tests := map[string]struct {
+		statuses []int
+		requests int
+	}{
+		"5xx retried":     {[]int{500, 503}, 3},
+		"429 retried":     {[]int{429}, 2},
+		"4xx not retried": {[]int{400}, 1},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := make(chan request, 10)
+			s := server(c, tt.statuses...)
+			defer s.Close()
+			o, _ := New(s.URL, Batch(batch.Size(1)))
+			o.Write([]byte("line\n"))
+			for i := 0; i < tt.requests; i++ {
+				select {
+				case <-c:
+				case <-time.After(5 * time.Second):
+					t.Fatalf("timeout waiting for request %d", i+1)
+				}
+			}
+			o.Close()
+			if got := len(c); got != 0 {
+				t.Errorf("got %d extra requests", got)
+			}
+		})
+	}
+}
+
+func TestFormatInvalid(t *testing.T) {
+	if _, err := New("http://localhost", Format("xml")); err == nil {
+		t.Error("expected error for invalid format")
+	}
+}
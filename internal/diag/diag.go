@@ -0,0 +1,24 @@
+// Package diag logs the diagnostics of golp itself, like write errors.
+//
+// Diagnostics are not written with the standard logger so they can be sent
+// away from the standard error when it is captured by golp, without
+// redirecting the logs of the program.
+package diag
+
+import (
+	"io"
+	"log"
+	"os"
+)
+
+var logger = log.New(os.Stderr, "", log.LstdFlags)
+
+// Printf logs a diagnostic message.
+func Printf(format string, v ...interface{}) {
+	logger.Printf(format, v...)
+}
+
+// SetOutput sets the destination of the diagnostics, os.Stderr by default.
+func SetOutput(w io.Writer) {
+	logger.SetOutput(w)
+}
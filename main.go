@@ -15,22 +15,92 @@
 //
 // Options:
 //
+//    -add-timestamp
+//        Add a timestamp key to the JSON or logfmt output (requires json or logfmt option).
 //    -allow-json
-//        Allow JSON input not to be escaped. When enabled, max-len is not efforced on JSON lines.
+//        Allow JSON input not to be escaped. Invalid JSON lines are handled as messages. With max-len, the longest string values of JSON lines are truncated until they fit.
+//    -check-config
+//        Validate the configuration, print the effective configuration and exit.
+//    -config string
+//        A JSON file with settings named after the options (i.e.: {"json": true, "ctx": {"service": "api"}}). Options given on the command line or environment override the file.
 //    -ctx value
-//        A key=value to add to the JSON output (can be repeated).
+//        A key=value to add to the JSON or logfmt output (can be repeated). Use key:=value for a raw JSON value like a number, a boolean or an object. Values may contain ${HOSTNAME}, ${env:NAME}, ${pid}, ${run_id} and ${seq} (event sequence number) placeholders.
+//    -ctx-nested
+//        Expand dotted ctx keys into nested JSON objects (i.e.: service.name=app).
+//    -drain-timeout duration
+//        On interrupt or SIGTERM, time given to read the remaining input before exiting. golp exits with status 0 if the input ends within this delay. A second signal exits immediately. Also the time given to the non-blocking queue to write its events before exiting. (default 5s)
+//    -drop-report-interval duration
+//        Interval of the dropped events report in non-blocking mode. (default 10s)
+//    -escape-invalid-utf8
+//        Keep invalid UTF-8 bytes as \u00XX escapes instead of replacing them by U+FFFD.
+//    -escape-line-terminators
+//        Escape U+2028 and U+2029 line terminators.
+//    -flush-delay duration
+//        Delay without new line after which an event is flushed. (default 5ms)
+//    -format string
+//        A Go text/template used to format each event (overrides json and logfmt options). Available fields are .Message (escaped), .Raw, .Kind (text, log, panic or trace), .Time, .Context and .Fields, and functions json and logfmt quote values (i.e.: '[{{.Context.program}}] <{{.Kind}}> {{.Message}}').
+//    -forward-ack
+//        Require forward server to acknowledge events.
+//    -forward-tag string
+//        The tag to use with forward output. (default "golp")
+//    -group string
+//        Continuation grouping mode: header (any line not starting a new event is a continuation) or indent (only indented, blank and stack lines continue a panic). (default "header")
+//    -http-format string
+//        The payload format for HTTP output: json, elasticsearch, loki or otlp. With loki, the context is used as stream labels. With otlp, the context is used as resource attributes. (default "json")
+//    -http-gzip
+//        Compress HTTP output requests with gzip.
+//    -http-header value
+//        A key=value header to add to HTTP output requests (can be repeated).
 //    -json
 //        Wrap messages to one JSON object per line.
 //    -json-key string
 //        The key name to use for the message in JSON mode. (default "message")
+//    -json-merge string
+//        Policy merging the context into JSON input: input (input values win), context (context values win) or nest (context under json-merge-key). (default "input")
+//    -json-merge-key string
+//        The key the context is nested under with the nest json-merge policy. (default "context")
+//    -json-truncate-field string
+//        The string field of JSON input lines truncated first when exceeding max-len (i.e.: message or stack).
+//    -logfmt
+//        Format messages as logfmt key=value pairs, with the context and the message as msg key.
+//    -max-event-age duration
+//        Flush events older than this duration and continue them as a follow-up event.
+//    -max-event-lines int
+//        Flush events with more lines than this and continue them as a follow-up event.
+//    -max-event-size int
+//        Flush events larger than this input size and continue them as a follow-up event (reported in flush_reason and continued fields).
 //    -max-len int
 //        Strip messages to not exceed this length.
+//    -non-blocking
+//        Queue events between reading and output so a slow output never blocks the program writing to golp. Events overflowing the queue are dropped and reported by a periodic "N events dropped" event.
 //    -output string
-//        A file to append events to. Default output is stdout.
+//        A file to append events to. Default output is stdout. Use unix: or unixgram: prefix for output on a UNIX socket. Use forward:host:port or forward:unix:path to send events to a Fluentd forward server (implies json option). Use an http:// or https:// URL to post batches of events (implies json option).
+//    -overflow string
+//        Policy for events overflowing the non-blocking queue: drop-oldest, drop-newest or sample (keeps one out of sample-rate events). (default "drop-oldest")
+//    -panic-flush-delay duration
+//        Delay used instead of flush-delay while inside a panic or goroutine dump, where more frames are expected (adaptive flushing).
+//    -pid int
+//        Pid of the program whose output is read, used for the ${pid} ctx placeholder (default to the pid of golp).
 //    -prefix string
 //        Go logger prefix set in the application if any.
+//    -print-env
+//        Print the environment variables setting the options and exit. Options are set by GOLP_ variables named after them (i.e.: GOLP_MAX_LEN=1024), and repeated options by a family of variables suffixed by the key (i.e.: GOLP_CTX_service=api). Variables named after an option take precedence over families (i.e.: GOLP_CTX_NESTED sets ctx-nested, not the NESTED ctx key). Command line options take precedence over environment variables, which take precedence over the config file.
+//    -profile string
+//        Comma separated list of runtimes whose traces are grouped into single events like Go panics (java, python and node).
+//    -queue-size int
+//        Maximum number of events queued in non-blocking mode. (default 10000)
+//    -sample-rate int
+//        Rate of the sample overflow policy. (default 10)
 //    -strip
-//        Strip log line timestamps on output.// Send panics and other program panics to syslog:
+//        Strip log line timestamps on output.
+//    -truncate string
+//        Truncation strategy when max-len is exceeded: head, tail, head-tail, panic (keeps the panicking goroutine) or split (splits the event in records linked by event_id, chunk and chunks fields). (default "head")
+//    -truncate-fields
+//        Add truncated and original_length fields to truncated events (requires json or logfmt option).
+//    -truncate-runes
+//        Count truncated lengths in runes instead of bytes.
+//
+// Send panics and other program panics to syslog:
 //
 //     mygoprogram 2>&1 | golp | logger -t mygoprogram -p local7.err
 //
@@ -41,23 +111,48 @@
 //     mygoprogram 2>&1 | golp --json | logger -t mygoprogram -p local7.err
 //
 //     > Jan  8 16:59:26 host mygoprogram: {"message": "panic: panic: test\n\ngoroutine 1 [running]:\npanic(0x…
+//
 // Add context:
 //
 //     mygoprogram 2>&1 | golp --json --ctx level=error --ctx program=mygoprogram
 //
 //     > {"level":"error","program":"mygoprogram","message":"panic: panic: test\n\ngoroutine 1 [running]:\npanic(0x…
+//
+// Add typed and nested context:
+//
+//     mygoprogram 2>&1 | golp --json --ctx-nested --ctx service.name=mygoprogram --ctx port:=8080
+//
+//     > {"port":8080,"service":{"name":"mygoprogram"},"message":"panic: panic: test\n\ngoroutine 1 [running]:\npanic(0x…
+//
+// Add per host and per event context:
+//
+//     mygoprogram 2>&1 | golp --json --ctx host='${HOSTNAME}' --ctx run='${run_id}' --ctx seq='${seq}'
+//
+//     > {"seq":1,"host":"web-1","run":"5f1c0e9a2b7d4c83","message":"panic: panic: test\n\ngoroutine 1 [running]:\npanic(0x…
 package main
 
 import (
+	"bytes"
+	gocontext "context"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"log"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/rs/golp/batch"
+	"github.com/rs/golp/event"
 	"github.com/rs/golp/file"
+	"github.com/rs/golp/forward"
 	"github.com/rs/golp/golp"
+	"github.com/rs/golp/httpout"
 )
 
 type context map[string]string
@@ -75,36 +170,261 @@ func (c *context) Set(value string) error {
 	return nil
 }
 
+func (c *context) has(key string) bool {
+	_, found := (*c)[key]
+	return found
+}
+
+// typedContext is a context also accepting key:=value pairs with a raw JSON
+// value. Typed values are kept as text in the context. The last value set for
+// a key wins, typed or not.
+type typedContext struct {
+	context
+	typed map[string]json.RawMessage
+}
+
+func newTypedContext() *typedContext {
+	return &typedContext{context{}, map[string]json.RawMessage{}}
+}
+
+func (c *typedContext) Set(value string) error {
+	i := strings.IndexByte(value, '=')
+	if i > 0 && value[i-1] == ':' {
+		v := json.RawMessage(value[i+1:])
+		if !json.Valid(v) {
+			return fmt.Errorf("invalid JSON value: %s", v)
+		}
+		c.typed[value[:i-1]] = v
+		value = value[:i-1] + value[i:]
+	} else if i > 0 {
+		delete(c.typed, value[:i])
+	}
+	return c.context.Set(value)
+}
+
 func main() {
+	// status is the exit status, set once deferred calls closed the outputs
+	status := 0
+	defer func() {
+		if status != 0 {
+			os.Exit(status)
+		}
+	}()
 	maxLen := flag.Int("max-len", 0, "Strip messages to not exceed this length.")
+	truncate := flag.String("truncate", "head", "Truncation strategy when max-len is exceeded: head, tail, head-tail, panic (keeps the panicking goroutine) or split (splits the event in records linked by event_id, chunk and chunks fields).")
+	truncateRunes := flag.Bool("truncate-runes", false, "Count truncated lengths in runes instead of bytes.")
+	truncateFields := flag.Bool("truncate-fields", false, "Add truncated and original_length fields to truncated events (requires json or logfmt option).")
+	maxEventSize := flag.Int("max-event-size", 0, "Flush events larger than this input size and continue them as a follow-up event (reported in flush_reason and continued fields).")
+	maxEventLines := flag.Int("max-event-lines", 0, "Flush events with more lines than this and continue them as a follow-up event.")
+	maxEventAge := flag.Duration("max-event-age", 0, "Flush events older than this duration and continue them as a follow-up event.")
+	flushDelay := flag.Duration("flush-delay", 5*time.Millisecond, "Delay without new line after which an event is flushed.")
+	panicFlushDelay := flag.Duration("panic-flush-delay", 0, "Delay used instead of flush-delay while inside a panic or goroutine dump, where more frames are expected (adaptive flushing).")
+	nonBlocking := flag.Bool("non-blocking", false, "Queue events between reading and output so a slow output never blocks the program writing to golp. "+
+		"Events overflowing the queue are dropped and reported by a periodic \"N events dropped\" event.")
+	queueSize := flag.Int("queue-size", 10000, "Maximum number of events queued in non-blocking mode.")
+	overflow := flag.String("overflow", "drop-oldest", "Policy for events overflowing the non-blocking queue: drop-oldest, drop-newest or sample (keeps one out of sample-rate events).")
+	sampleRate := flag.Int("sample-rate", 10, "Rate of the sample overflow policy.")
+	dropReportInterval := flag.Duration("drop-report-interval", 10*time.Second, "Interval of the dropped events report in non-blocking mode.")
+	drainTimeout := flag.Duration("drain-timeout", 5*time.Second, "On interrupt or SIGTERM, time given to read the remaining input before exiting. "+
+		"golp exits with status 0 if the input ends within this delay. A second signal exits immediately. "+
+		"Also the time given to the non-blocking queue to write its events before exiting.")
+	group := flag.String("group", "header", "Continuation grouping mode: header (any line not starting a new event is a continuation) or indent (only indented, blank and stack lines continue a panic).")
+	profiles := flag.String("profile", "", "Comma separated list of runtimes whose traces are grouped into single events like Go panics (java, python and node).")
 	prefix := flag.String("prefix", "", "Go logger prefix set in the application if any.")
 	strip := flag.Bool("strip", false, "Strip log line timestamps on output.")
 	json := flag.Bool("json", false, "Wrap messages to one JSON object per line.")
-	allowJSON := flag.Bool("allow-json", false, "Allow JSON input not to be escaped. When enabled, max-len is not efforced on JSON lines.")
+	allowJSON := flag.Bool("allow-json", false, "Allow JSON input not to be escaped. Invalid JSON lines are handled as messages. With max-len, the longest string values of JSON lines are truncated until they fit.")
+	jsonMerge := flag.String("json-merge", "input", "Policy merging the context into JSON input: input (input values win), context (context values win) or nest (context under json-merge-key).")
+	jsonMergeKey := flag.String("json-merge-key", "context", "The key the context is nested under with the nest json-merge policy.")
+	jsonTruncateField := flag.String("json-truncate-field", "", "The string field of JSON input lines truncated first when exceeding max-len (i.e.: message or stack).")
 	jsonKey := flag.String("json-key", "message", "The key name to use for the message in JSON mode.")
-	addTimestamp := flag.Bool("add-timestamp", false, "Add a timestamp key to the JSON output (requires json option).")
+	logfmt := flag.Bool("logfmt", false, "Format messages as logfmt key=value pairs, with the context and the message as msg key.")
+	format := flag.String("format", "", "A Go text/template used to format each event (overrides json and logfmt options). "+
+		"Available fields are .Message (escaped), .Raw, .Kind (text, log, panic or trace), .Time, .Context and .Fields, "+
+		"and functions json and logfmt quote values (i.e.: '[{{.Context.program}}] <{{.Kind}}> {{.Message}}').")
+	escapeInvalid := flag.Bool("escape-invalid-utf8", false, "Keep invalid UTF-8 bytes as \\u00XX escapes instead of replacing them by U+FFFD.")
+	escapeLineSep := flag.Bool("escape-line-terminators", false, "Escape U+2028 and U+2029 line terminators.")
+	addTimestamp := flag.Bool("add-timestamp", false, "Add a timestamp key to the JSON or logfmt output (requires json or logfmt option).")
 	output := flag.String("output", "", "A file to append events to. Default output is stdout. "+
-		"Use unix: or unixgram: prefix for output on a UNIX socket.")
-	ctx := context{}
-	flag.Var(&ctx, "ctx", "A key=value to add to the JSON output (can be repeated).")
+		"Use unix: or unixgram: prefix for output on a UNIX socket. "+
+		"Use forward:host:port or forward:unix:path to send events to a Fluentd forward server (implies json option). "+
+		"Use an http:// or https:// URL to post batches of events (implies json option).")
+	forwardTag := flag.String("forward-tag", "golp", "The tag to use with forward output.")
+	forwardAck := flag.Bool("forward-ack", false, "Require forward server to acknowledge events.")
+	httpFormat := flag.String("http-format", "json", "The payload format for HTTP output: json, elasticsearch, loki or otlp. "+
+		"With loki, the context is used as stream labels. With otlp, the context is used as resource attributes.")
+	httpGzip := flag.Bool("http-gzip", false, "Compress HTTP output requests with gzip.")
+	httpHeaders := context{}
+	flag.Var(&httpHeaders, "http-header", "A key=value header to add to HTTP output requests (can be repeated).")
+	ctx := newTypedContext()
+	flag.Var(ctx, "ctx", "A key=value to add to the JSON or logfmt output (can be repeated). "+
+		"Use key:=value for a raw JSON value like a number, a boolean or an object. "+
+		"Values may contain ${HOSTNAME}, ${env:NAME}, ${pid}, ${run_id} and ${seq} (event sequence number) placeholders.")
+	ctxNested := flag.Bool("ctx-nested", false, "Expand dotted ctx keys into nested JSON objects (i.e.: service.name=app).")
+	pid := flag.Int("pid", 0, "Pid of the program whose output is read, used for the ${pid} ctx placeholder (default to the pid of golp).")
+	config := flag.String("config", "", "A JSON file with settings named after the options (i.e.: {\"json\": true, \"ctx\": {\"service\": \"api\"}}). "+
+		"Options given on the command line or environment override the file.")
+	checkConfig := flag.Bool("check-config", false, "Validate the configuration, print the effective configuration and exit.")
+	printEnvVars := flag.Bool("print-env", false, "Print the environment variables setting the options and exit. "+
+		"Options are set by GOLP_ variables named after them (i.e.: GOLP_MAX_LEN=1024), and repeated options by a family "+
+		"of variables suffixed by the key (i.e.: GOLP_CTX_service=api). Variables named after an option take precedence over "+
+		"families (i.e.: GOLP_CTX_NESTED sets ctx-nested, not the NESTED ctx key). Command line options take precedence over "+
+		"environment variables, which take precedence over the config file.")
 	flag.Parse()
-	if !*json {
-		*jsonKey = ""
+	if *printEnvVars {
+		if err := printEnv(flag.CommandLine, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
+	if err := loadEnv(flag.CommandLine, os.Environ()); err != nil {
+		log.Fatal(err)
+	}
+	if *config != "" {
+		if err := loadConfig(flag.CommandLine, *config); err != nil {
+			log.Fatal(err)
+		}
+	}
+	// The configuration is printed as given, once validated
+	printed := &bytes.Buffer{}
+	if *checkConfig {
+		if err := printConfig(flag.CommandLine, printed); err != nil {
+			log.Fatal(err)
+		}
+	}
+	// Outputs add the context once per record or batch, without the per
+	// event values
+	static := golp.StaticContext(ctx.context, *pid)
 	var out io.Writer = os.Stdout
-	if *output != "" {
-		out = file.Output{*output}
+	if strings.HasPrefix(*output, "forward:") {
+		addr := (*output)[len("forward:"):]
+		network := "tcp"
+		if strings.HasPrefix(addr, "unix:") {
+			network, addr = "unix", addr[len("unix:"):]
+		}
+		o, err := forward.New(network, addr, *forwardTag,
+			forward.Record(*jsonKey, static),
+			forward.Ack(*forwardAck))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer o.Close()
+		out = o
+		*json = true
+	} else if strings.HasPrefix(*output, "http://") || strings.HasPrefix(*output, "https://") {
+		options := []httpout.Option{
+			httpout.Format(*httpFormat),
+			httpout.Gzip(*httpGzip),
+			httpout.Labels(static),
+			httpout.Record(*jsonKey, static),
+			httpout.TypedContext(ctx.typed, *ctxNested),
+		}
+		for k, v := range httpHeaders {
+			options = append(options, httpout.Header(k, v))
+		}
+		o, err := httpout.New(*output, options...)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer o.Close()
+		out = o
+		*json = true
+	} else if *output != "" {
+		out = file.Output{Path: *output}
+	}
+	jsonKeySet := false
+	flag.Visit(func(f *flag.Flag) {
+		jsonKeySet = jsonKeySet || f.Name == "json-key"
+	})
+	if *logfmt && !*json {
+		if !jsonKeySet {
+			*jsonKey = "msg"
+		}
+	} else if !*json {
+		*jsonKey = ""
 	}
 	g := golp.Golp{
 		In:           os.Stdin,
 		Out:          out,
-		Context:      ctx,
+		Context:      ctx.context,
 		MaxLen:       *maxLen,
 		Prefix:       *prefix,
 		Strip:        *strip,
 		AllowJSON:    *allowJSON,
 		MessageKey:   *jsonKey,
+		Logfmt:       *logfmt && !*json,
+		Format:       *format,
 		AddTimestamp: *addTimestamp,
+
+		EscapeInvalidUTF8:     *escapeInvalid,
+		EscapeLineTerminators: *escapeLineSep,
+		TruncateRunes:         *truncateRunes,
+		TruncationFields:      *truncateFields,
+		Truncation:            *truncate,
+		MaxEventSize:          *maxEventSize,
+		MaxEventLines:         *maxEventLines,
+		MaxEventAge:           *maxEventAge,
+		FlushDelay:            *flushDelay,
+		PanicFlushDelay:       *panicFlushDelay,
+		DrainTimeout:          *drainTimeout,
+		Grouping:              *group,
+		JSONMerge:             *jsonMerge,
+		JSONMergeKey:          *jsonMergeKey,
+		JSONTruncateField:     *jsonTruncateField,
+		TypedContext:          ctx.typed,
+		NestedContext:         *ctxNested,
+		PID:                   *pid,
+	}
+	if *profiles != "" {
+		g.Profiles = strings.Split(*profiles, ",")
+	}
+	if *nonBlocking {
+		options := []batch.Option{
+			batch.Limit(*queueSize),
+			batch.CloseTimeout(*drainTimeout),
+			batch.Report(*dropReportInterval, func(dropped int) []byte {
+				b, _ := g.FormatEvent(fmt.Sprintf("golp: %d events dropped", dropped), []event.Attr{
+					{Key: "dropped", Value: []byte(strconv.Itoa(dropped))},
+				})
+				return b
+			}),
+		}
+		if *overflow == batch.Sample {
+			options = append(options, batch.SampleRate(*sampleRate))
+		} else {
+			options = append(options, batch.Overflow(*overflow))
+		}
+		q, err := batch.NewWriter(g.Out, options...)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer q.Close()
+		g.Out = q
 	}
-	g.Run()
+	if *checkConfig {
+		if err := g.Validate(); err != nil {
+			log.Fatal(err)
+		}
+		printed.WriteTo(os.Stdout)
+		return
+	}
+	if err := g.RunContext(signalContext()); err != nil {
+		log.Print(err)
+		status = 1
+	}
+}
+
+// signalContext returns a context done on interrupt or SIGTERM, starting the
+// drain of the input. A second signal exits immediately.
+func signalContext() gocontext.Context {
+	ctx, cancel := gocontext.WithCancel(gocontext.Background())
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		cancel()
+		<-c
+		os.Exit(1)
+	}()
+	return ctx
 }
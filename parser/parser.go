@@ -4,7 +4,13 @@ package parser
 import "bytes"
 
 var (
-	panicPrefix       = []byte("panic: ")
+	panicPrefix     = []byte("panic: ")
+	goroutinePrefix = []byte("goroutine ")
+	stackPrefixes   = [][]byte{
+		[]byte("created by "),
+		[]byte("[signal "),
+		[]byte("...additional frames elided..."),
+	}
 	logPrefixPatterns = [][]byte{
 		[]byte("2000/01/02 12:00:00.000000 "),
 		[]byte("2000/01/02 12:00:00 "),
@@ -19,6 +25,32 @@ func IsPanic(line []byte) bool {
 	return bytes.HasPrefix(line, panicPrefix)
 }
 
+// IsGoroutine returns true if the line is the header of a goroutine stack as
+// found in panics and goroutine dumps (i.e.: goroutine 1 [running]:).
+func IsGoroutine(line []byte) bool {
+	return bytes.HasPrefix(line, goroutinePrefix) &&
+		len(line) > len(goroutinePrefix) && isNumber(line[len(goroutinePrefix)])
+}
+
+// IsStackLine returns true if the line has the shape of a line of a Go panic
+// or goroutine dump following its header: an indented or blank line, a
+// goroutine header, a function call frame (i.e.: main.main()) or a known
+// runtime line like created by.
+func IsStackLine(line []byte) bool {
+	if len(line) == 0 || line[0] == '\t' || line[0] == ' ' || IsPanic(line) || IsGoroutine(line) {
+		return true
+	}
+	for _, prefix := range stackPrefixes {
+		if bytes.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	// Function call frame: a function name without space followed by its
+	// arguments
+	i := bytes.IndexByte(line, '(')
+	return i > 0 && line[len(line)-1] == ')' && bytes.IndexByte(line[:i], ' ') == -1
+}
+
 // IsLog returns the index of the begining of the log message if the line
 // is the first line of log produced by the Go logger. If not a log message,
 // -1 is returned.
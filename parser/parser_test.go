@@ -19,6 +19,51 @@ func TestIsPanic(t *testing.T) {
 	}
 }
 
+func TestIsGoroutine(t *testing.T) {
+	tests := []struct {
+		line string
+		want bool
+	}{
+		{"goroutine 1 [running]:", true},
+		{"goroutine 18 [chan receive, 2 minutes]:", true},
+		{"goroutine ", false},
+		{"goroutine leak detected", false},
+		{"main.main()", false},
+	}
+	for _, tt := range tests {
+		if got := IsGoroutine([]byte(tt.line)); got != tt.want {
+			t.Errorf("match failed with %q: got %v want %v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestIsStackLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want bool
+	}{
+		{"", true},
+		{"\t/go/src/main.go:12 +0x1d", true},
+		{"  indented", true},
+		{"goroutine 1 [running]:", true},
+		{"main.main()", true},
+		{"github.com/rs/golp/event.(*Event).Flush(0xc420010000)", true},
+		{"panic({0x4a0, 0xc0})", true},
+		{"created by main.main", true},
+		{"[signal SIGSEGV: segmentation violation code=0x1 addr=0x0 pc=0x4a0]", true},
+		{"panic: boom [recovered]", true},
+		{"new message", false},
+		{"call foo(bar)", false},
+		{"(foo)", false},
+		{"2017/01/06 16:25:18 log line", false},
+	}
+	for _, tt := range tests {
+		if got := IsStackLine([]byte(tt.line)); got != tt.want {
+			t.Errorf("match failed with %q: got %v want %v", tt.line, got, tt.want)
+		}
+	}
+}
+
 func TestIsLog(t *testing.T) {
 	tests := []struct {
 		prefix string
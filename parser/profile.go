@@ -0,0 +1,137 @@
+package parser
+
+import "bytes"
+
+// Profile recognizes the multiline traces of a runtime so they can be grouped
+// into single events like Go panics.
+type Profile struct {
+	// Name is the name the profile is selected by.
+	Name string
+	// IsHeader returns true if the line is the first line of a trace.
+	IsHeader func(line []byte) bool
+	// IsContinuation returns true if the line is part of the current trace.
+	IsContinuation func(line []byte) bool
+	// IsLast returns true if the line is the last line of the trace, only
+	// followed by blank lines or chain lines. May be nil.
+	IsLast func(line []byte) bool
+	// IsChain returns true if the line announces another trace chained to
+	// the current one. The header of the chained trace is then grouped into
+	// the current trace. May be nil.
+	IsChain func(line []byte) bool
+}
+
+var (
+	javaHeaderPrefix    = []byte("Exception in thread ")
+	javaCausePrefixes   = [][]byte{[]byte("Caused by: "), []byte("Suppressed: ")}
+	pythonHeader        = []byte("Traceback (most recent call last):")
+	pythonChainPrefixes = [][]byte{[]byte("During handling of the above exception"), []byte("The above exception was the direct cause")}
+	nodeVersionPrefix   = []byte("Node.js v")
+	nodeUncaughtPrefix  = []byte("Uncaught ")
+)
+
+// Java recognizes Java exceptions with their at, Caused by and ... N more
+// lines.
+var Java = Profile{
+	Name: "java",
+	IsHeader: func(line []byte) bool {
+		return bytes.HasPrefix(line, javaHeaderPrefix) || isJavaException(line)
+	},
+	IsContinuation: func(line []byte) bool {
+		return isIndented(line) || hasAnyPrefix(line, javaCausePrefixes)
+	},
+}
+
+// isJavaException returns true if the line starts with a fully qualified
+// Java exception class name.
+func isJavaException(line []byte) bool {
+	name := line
+	if i := bytes.IndexByte(line, ':'); i != -1 {
+		name = line[:i]
+	}
+	return bytes.IndexByte(name, '.') != -1 && isException(line, "Exception", "Error", "Throwable")
+}
+
+// Python recognizes Python tracebacks up to their final exception line,
+// including chained tracebacks.
+var Python = Profile{
+	Name: "python",
+	IsHeader: func(line []byte) bool {
+		return bytes.Equal(line, pythonHeader)
+	},
+	IsContinuation: func(line []byte) bool {
+		return len(line) == 0 || isIndented(line) || hasAnyPrefix(line, pythonChainPrefixes) || isPythonException(line)
+	},
+	IsLast: isPythonException,
+	IsChain: func(line []byte) bool {
+		return hasAnyPrefix(line, pythonChainPrefixes)
+	},
+}
+
+// isPythonException returns true if the line is the exception line ending a
+// Python traceback.
+func isPythonException(line []byte) bool {
+	return isException(line, "Error", "Exception", "Warning", "Interrupt", "Exit")
+}
+
+// Node recognizes Node.js errors followed by their at lines.
+var Node = Profile{
+	Name: "node",
+	IsHeader: func(line []byte) bool {
+		return bytes.HasPrefix(line, nodeUncaughtPrefix) || isException(line, "Error")
+	},
+	IsContinuation: func(line []byte) bool {
+		return len(line) == 0 || isIndented(line) || bytes.HasPrefix(line, nodeVersionPrefix)
+	},
+}
+
+// Profiles are the available profiles by name.
+var Profiles = map[string]Profile{
+	Java.Name:   Java,
+	Python.Name: Python,
+	Node.Name:   Node,
+}
+
+// isIndented returns true if the line starts with a tab or a space.
+func isIndented(line []byte) bool {
+	return len(line) > 0 && (line[0] == '\t' || line[0] == ' ')
+}
+
+// hasAnyPrefix returns true if the line starts with one of the prefixes.
+func hasAnyPrefix(line []byte, prefixes [][]byte) bool {
+	for _, prefix := range prefixes {
+		if bytes.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isException returns true if the line starts with a possibly qualified
+// exception name ending with one of the suffixes, followed by a colon or the
+// end of the line (i.e.: java.lang.IllegalStateException: message).
+func isException(line []byte, suffixes ...string) bool {
+	end := bytes.IndexByte(line, ':')
+	if end == -1 {
+		end = len(line)
+	}
+	name := line[:end]
+	if len(name) == 0 || !isIdentStart(name[0]) {
+		return false
+	}
+	for _, b := range name {
+		if !isIdentStart(b) && !isNumber(b) && b != '.' && b != '$' {
+			return false
+		}
+	}
+	for _, suffix := range suffixes {
+		if bytes.HasSuffix(name, []byte(suffix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isIdentStart returns true if b is an ASCII letter or an underscore.
+func isIdentStart(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b == '_'
+}
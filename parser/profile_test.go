@@ -0,0 +1,41 @@
+package parser
+
+import "testing"
+
+func TestProfiles(t *testing.T) {
+	tests := []struct {
+		profile      Profile
+		line         string
+		header       bool
+		continuation bool
+	}{
+		{Java, `Exception in thread "main" java.lang.IllegalStateException: boom`, true, false},
+		{Java, "java.lang.NullPointerException", true, false},
+		{Java, "com.example.MyError: failed", true, false},
+		{Java, "TypeError: boom", false, false},
+		{Java, "\tat com.example.Main.main(Main.java:5)", false, true},
+		{Java, "Caused by: java.io.IOException: closed", false, true},
+		{Java, "\t... 3 more", false, true},
+		{Java, "Started application", false, false},
+		{Python, "Traceback (most recent call last):", true, false},
+		{Python, `  File "app.py", line 3, in <module>`, false, true},
+		{Python, "ValueError: invalid literal", false, true},
+		{Python, "KeyboardInterrupt", false, true},
+		{Python, "", false, true},
+		{Python, "During handling of the above exception, another exception occurred:", false, true},
+		{Python, "Starting server", false, false},
+		{Node, "TypeError: Cannot read properties of undefined (reading 'x')", true, false},
+		{Node, "Uncaught Error: boom", true, false},
+		{Node, "    at Object.<anonymous> (/app/index.js:3:7)", false, true},
+		{Node, "Node.js v18.12.1", false, true},
+		{Node, "Listening on port 3000", false, false},
+	}
+	for _, tt := range tests {
+		if got := tt.profile.IsHeader([]byte(tt.line)); got != tt.header {
+			t.Errorf("%s header %q: got %v want %v", tt.profile.Name, tt.line, got, tt.header)
+		}
+		if got := tt.profile.IsContinuation([]byte(tt.line)); got != tt.continuation {
+			t.Errorf("%s continuation %q: got %v want %v", tt.profile.Name, tt.line, got, tt.continuation)
+		}
+	}
+}